@@ -1,5 +1,10 @@
 module github.com/omaskery/optimistic-gorm
 
-go 1.16
+go 1.18
 
 require gorm.io/gorm v1.21.15
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.2 // indirect
+)
@@ -0,0 +1,48 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// UpdateAssociation changes the named association of the model with GORM's association mode as a logical update of the
+// model itself, so that replacing, appending to, deleting from or clearing its relationships moves its version on, e.g.
+// optimistic.UpdateAssociation(db, &parent, "Children", replace) where replace calls the association's Replace.
+// The change is made in a transaction along with a guarded update of the model, so it is rolled back if the model has
+// been modified concurrently. GORM saves the model itself when appending to or replacing some kinds of association, in
+// which case that (guarded) save is the update, so the version only ever moves on once. It returns ErrNotLocked if the
+// model is not locked
+func UpdateAssociation(tx *gorm.DB, model interface{}, name string, change func(*gorm.Association) error) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	column, ok := lockColumnOf(stmt, model)
+	if !ok {
+		return ErrNotLocked
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return ErrNotLocked
+	}
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	lockValue := func() interface{} {
+		value, _ := field.ValueOf(rv)
+		return value
+	}
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		before := lockValue()
+		if err := change(tx.Model(model).Association(name)); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(lockValue(), before) {
+			// the change saved the model, and so has already moved its lock on
+			return nil
+		}
+
+		// the lock value is added to the otherwise empty update by the hooks guarding it
+		return tx.Model(model).Updates(map[string]interface{}{}).Error
+	})
+}
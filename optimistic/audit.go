@@ -0,0 +1,78 @@
+package optimistic
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// VersionAudit is a row of a version audit table, recording a guarded write that moved a model on from the version it
+// was read at. Versions and primary keys are recorded as text, so that one layout suits every kind of lock
+type VersionAudit struct {
+	ID uint `gorm:"primaryKey"`
+	// PrimaryKey is the primary key of the written model, composite primary keys are given as a list
+	PrimaryKey string `gorm:"index"`
+	// Operation is the kind of write made, as given by Operation.String
+	Operation string
+	// OldVersion is the version the model was read at
+	OldVersion string
+	// NewVersion is the version the write moved the model to, which is empty for hard deletes and for locks the
+	// database moves on by itself
+	NewVersion string
+	// Timestamp is when the write was made
+	Timestamp time.Time
+}
+
+// defaultAuditTableName names the audit table of a table when PluginOptions.AuditTableName is not set
+func defaultAuditTableName(table string) string {
+	return table + "_versions"
+}
+
+// auditTableName returns the name of the audit table for the given table, as configured on the Plugin if one is given
+func auditTableName(plugin *Plugin, table string) string {
+	if plugin != nil && plugin.opts.AuditTableName != nil {
+		return plugin.opts.AuditTableName(table)
+	}
+	return defaultAuditTableName(table)
+}
+
+// MigrateVersionAudit creates (or migrates) the audit tables of the given models, named as configured on the Plugin
+// installed on the database
+func MigrateVersionAudit(db *gorm.DB, models ...interface{}) error {
+	plugin, _ := installedPlugin(db)
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		if err := db.Table(auditTableName(plugin, stmt.Table)).AutoMigrate(&VersionAudit{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditTransition records the lock value of the model currently being processed by the statement moving on in its
+// table's audit table, if the Plugin is configured to. The record is written through tx, so that it is made in the
+// same transaction as the write
+func auditTransition(tx *gorm.DB, stmt *gorm.Statement, l lock, op Operation) error {
+	plugin, ok := installedPlugin(stmt.DB)
+	if !ok || !plugin.opts.AuditVersions {
+		return nil
+	}
+
+	record := &VersionAudit{
+		PrimaryKey: fmt.Sprint(primaryKeyOf(stmt)),
+		Operation:  op.String(),
+		OldVersion: fmt.Sprint(l.readValue()),
+		Timestamp:  tx.NowFunc(),
+	}
+	if op != OperationHardDelete && !isAdvancedByDatabase(l) {
+		record.NewVersion = fmt.Sprint(l.currentValue())
+	}
+
+	return tx.Session(&gorm.Session{NewDB: true}).Table(auditTableName(plugin, stmt.Table)).Create(record).Error
+}
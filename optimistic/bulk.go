@@ -0,0 +1,284 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BulkUpdate writes every field of each of the models as GORM's Save does, but in a single UPDATE statement that guards
+// each row on the version its model was read at. Models that have been modified since they were read are left unwritten
+// and returned as *ConflictErrors, models whose rows have since been deleted are left unwritten and returned as
+// *MissingRowErrors, and the rest are written and have their versions moved on. The returned errors are in the order of
+// their models, just as the Plugin would have failed each model's own update. The rows are checked and written in a
+// transaction, and any other error leaves all of them unwritten and returns the models to the state they were in before
+// BulkUpdate was called. Writes are always guarded, whatever scopes the *gorm.DB was given, and fail with
+// ErrUnknownReadVersion without writing anything if any of the models was never read
+func BulkUpdate[T any](tx *gorm.DB, models []*T) ([]error, error) {
+	if len(models) == 0 {
+		return nil, nil
+	}
+
+	// keep any table named with GORM's Table, which parsing the model only fills in if there is none
+	stmt := &gorm.Statement{DB: tx, Table: tx.Statement.Table}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	rows := make([]*bulkRow, 0, len(models))
+	for _, model := range models {
+		row, err := newBulkRow(stmt, model)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	previous := make([]T, 0, len(models))
+	for _, model := range models {
+		previous = append(previous, *model)
+	}
+
+	var written, unwritten []*bulkRow
+	var fresh map[string]bool
+	err := tx.Transaction(func(tx *gorm.DB) (err error) {
+		fresh, err = freshBulkRows(tx, stmt, rows)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if fresh[row.key] {
+				written = append(written, row)
+			} else {
+				unwritten = append(unwritten, row)
+			}
+		}
+
+		if err := writeBulkRows(tx, stmt, written); err != nil {
+			return err
+		}
+
+		for _, row := range written {
+			stmt.ReflectValue = row.value
+			if err := auditTransition(tx, stmt, row.lock, OperationUpdate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for i, model := range models {
+			*model = previous[i]
+		}
+		return nil, err
+	}
+
+	metrics := metricsFor(stmt)
+	failures := make([]error, 0, len(unwritten))
+	for _, row := range unwritten {
+		stmt.ReflectValue = row.value
+		if _, found := fresh[row.key]; !found {
+			failures = append(failures, &MissingRowError{
+				Operation:  OperationUpdate,
+				Table:      stmt.Table,
+				PrimaryKey: primaryKeyOf(stmt),
+			})
+			continue
+		}
+
+		conflict := newConflictError(stmt, OperationUpdate)
+		row.lock.describe(conflict)
+		metrics.recordConflict(stmt.Table)
+		recordTransactionConflict(tx.Statement, conflict)
+		reportConflict(tx.Statement, conflict)
+		failures = append(failures, conflict)
+	}
+	for range written {
+		metrics.recordWrite(stmt.Table, OperationUpdate)
+	}
+
+	return failures, nil
+}
+
+// bulkRow is one of the models written by BulkUpdate
+type bulkRow struct {
+	value reflect.Value
+	lock  lock
+	// match is a condition matching the model's row by primary key
+	match clause.Expr
+	// key identifies the model's primary key, so that rows read back from the database can be matched to models
+	key string
+}
+
+func newBulkRow(stmt *gorm.Statement, model interface{}) (*bulkRow, error) {
+	row := &bulkRow{
+		value: reflect.Indirect(reflect.ValueOf(model)),
+	}
+
+	if l, ok := model.(lock); ok {
+		if l.unread() {
+			return nil, ErrUnknownReadVersion
+		}
+		row.lock = l
+	} else if field, ok := lockField(stmt.Schema); ok {
+		row.lock = newFieldLock(field, row.value, nil)
+	} else {
+		return nil, ErrNotLocked
+	}
+
+	var conditions []string
+	var values []interface{}
+	allZero := true
+	for _, field := range stmt.Schema.PrimaryFields {
+		value, isZero := field.ValueOf(row.value)
+		allZero = allZero && isZero
+		conditions = append(conditions, "? = ?")
+		row.match.Vars = append(row.match.Vars, clause.Column{Name: field.DBName}, value)
+		values = append(values, value)
+	}
+	if allZero {
+		return nil, gorm.ErrPrimaryKeyRequired
+	}
+	row.match.SQL = strings.Join(conditions, " AND ")
+	row.key = bulkKey(values)
+
+	return row, nil
+}
+
+// bulkKey identifies a primary key by its values, however they are typed
+func bulkKey(values []interface{}) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface()))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// freshBulkRows reads which of the rows still hold the lock value their model was read at, locking them (where the
+// database supports it) so that they cannot be modified before they are written. Rows for models that were deleted
+// are missing from the result
+func freshBulkRows(tx *gorm.DB, stmt *gorm.Statement, rows []*bulkRow) (map[string]bool, error) {
+	column := clause.Column{Name: rows[0].lock.lockColumn(stmt)}
+
+	var selects strings.Builder
+	var vars []interface{}
+	for _, field := range stmt.Schema.PrimaryFields {
+		selects.WriteString("?, ")
+		vars = append(vars, clause.Column{Name: field.DBName})
+	}
+	selects.WriteString("CASE")
+	matches := make([]clause.Expr, 0, len(rows))
+	for _, row := range rows {
+		matches = append(matches, row.match)
+		selects.WriteString(" WHEN ? THEN ? = ?")
+		vars = append(vars, row.match, column, row.lock.readValue())
+	}
+	selects.WriteString(" END")
+
+	result, err := tx.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Table(stmt.Table).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select(selects.String(), vars...).
+		Where(anyOf(matches)).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	fresh := make(map[string]bool, len(rows))
+	for result.Next() {
+		dest := make([]interface{}, 0, len(stmt.Schema.PrimaryFields)+1)
+		for _, field := range stmt.Schema.PrimaryFields {
+			dest = append(dest, reflect.New(field.IndirectFieldType).Interface())
+		}
+		var current bool
+		if err := result.Scan(append(dest, &current)...); err != nil {
+			return nil, err
+		}
+		fresh[bulkKey(dest)] = current
+	}
+
+	return fresh, result.Err()
+}
+
+// writeBulkRows writes the rows in a single UPDATE, setting each column to the row's own value and moving each row's
+// lock on. The rows must already have been found to be fresh, so any that no longer match their guard mean that the
+// database could not lock them
+func writeBulkRows(tx *gorm.DB, stmt *gorm.Statement, rows []*bulkRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	column := rows[0].lock.lockColumn(stmt)
+	guards := make([]clause.Expr, 0, len(rows))
+	for _, row := range rows {
+		guards = append(guards, clause.Expr{
+			SQL:  "? AND ? = ?",
+			Vars: []interface{}{row.match, clause.Column{Name: column}, row.lock.readValue()},
+		})
+	}
+
+	now := tx.NowFunc()
+	for _, row := range rows {
+		for _, field := range stmt.Schema.Fields {
+			if field.AutoUpdateTime > 0 {
+				if err := field.Set(row.value, now); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !isAdvancedByDatabase(row.lock) {
+			stmt.ReflectValue = row.value
+			if _, err := row.lock.advance(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	assignments := map[string]interface{}{}
+	for _, dbName := range stmt.Schema.DBNames {
+		field := stmt.Schema.FieldsByDBName[dbName]
+		if field.PrimaryKey || !field.Updatable {
+			continue
+		}
+
+		var assignment strings.Builder
+		var vars []interface{}
+		assignment.WriteString("CASE")
+		for _, row := range rows {
+			value, _ := field.ValueOf(row.value)
+			assignment.WriteString(" WHEN ? THEN ?")
+			vars = append(vars, row.match, value)
+		}
+		assignment.WriteString(" END")
+		assignments[dbName] = clause.Expr{SQL: assignment.String(), Vars: vars}
+	}
+
+	update := tx.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Table(stmt.Table).
+		Where(anyOf(guards)).
+		UpdateColumns(assignments)
+	if update.Error != nil {
+		return update.Error
+	}
+	if update.RowsAffected != int64(len(rows)) {
+		return ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// anyOf combines the conditions into one matching any of them
+func anyOf(conditions []clause.Expr) clause.Expr {
+	expr := clause.Expr{SQL: strings.TrimSuffix(strings.Repeat("(?) OR ", len(conditions)), " OR ")}
+	for _, condition := range conditions {
+		expr.Vars = append(expr.Vars, condition)
+	}
+	return expr
+}
@@ -0,0 +1,10 @@
+package optimistic
+
+// Clone returns a copy of the model that was read at the same version, so that concurrent goroutines can each edit and
+// write their own copy of a model read once, with each write guarded on the version the original was read at. The copy
+// is shallow, so the copies share any slices, maps or pointers (such as associations) the model holds, which must not
+// be modified through both
+func Clone[T any](model *T) *T {
+	copied := *model
+	return &copied
+}
@@ -0,0 +1,256 @@
+package optimistic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ConflictInfo describes a detected concurrent modification to a callback registered with OnConflict
+type ConflictInfo struct {
+	// Operation is the kind of write that detected the conflict
+	Operation Operation
+	// Table is the name of the table the conflicting operation was applied to
+	Table string
+	// PrimaryKey is the primary key of the conflicting model, composite primary keys are given as a []interface{}
+	PrimaryKey interface{}
+	// ExpectedVersion is the version the model was read at, which the database no longer holds
+	ExpectedVersion uint64
+	// ExpectedToken is the token the model was read at, for models locked by something other than a version number
+	ExpectedToken string
+}
+
+// ConflictVetoer can be implemented by models to decide, when a write to them conflicts, whether the conflict should
+// fail the write. OnConflictDetected is called with the context of the conflicting statement once the conflict has been
+// reported to any OnConflict callbacks. Returning true lets the write succeed despite having changed nothing, returning
+// an error fails the write with that error instead of the conflict, and returning neither fails it with the conflict
+type ConflictVetoer interface {
+	OnConflictDetected(ctx context.Context) (proceed bool, err error)
+}
+
+// ConflictCallback is called whenever a concurrent modification is detected
+type ConflictCallback func(ctx context.Context, info ConflictInfo)
+
+var conflictCallbacks = struct {
+	sync.RWMutex
+	registered []*ConflictCallback
+}{}
+
+// OnConflict registers a callback to be called, with the context of the conflicting statement, whenever a concurrent
+// modification is detected. Callbacks are called in the order they were registered, just before the conflict error is
+// returned. The returned function unregisters the callback again
+func OnConflict(callback ConflictCallback) (unregister func()) {
+	registration := &callback
+
+	conflictCallbacks.Lock()
+	defer conflictCallbacks.Unlock()
+	conflictCallbacks.registered = append(conflictCallbacks.registered, registration)
+
+	return func() {
+		conflictCallbacks.Lock()
+		defer conflictCallbacks.Unlock()
+
+		for i, registered := range conflictCallbacks.registered {
+			if registered == registration {
+				conflictCallbacks.registered = append(conflictCallbacks.registered[:i:i],
+					conflictCallbacks.registered[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Info returns the details of the conflict as given to callbacks registered with OnConflict
+func (e *ConflictError) Info() ConflictInfo {
+	return ConflictInfo{
+		Operation:       e.Operation,
+		Table:           e.Table,
+		PrimaryKey:      e.PrimaryKey,
+		ExpectedVersion: e.ExpectedVersion,
+		ExpectedToken:   e.ExpectedToken,
+	}
+}
+
+type conflictContextKey struct{}
+
+// contextConflict holds the most recent conflict detected by a statement using a context returned by
+// WithConflictTracking
+type contextConflict struct {
+	sync.Mutex
+	info     ConflictInfo
+	detected bool
+}
+
+// WithConflictTracking returns a context in which conflicts detected by statements using it (e.g. through
+// db.WithContext) are recorded, so that code further up the call stack, such as logging middleware, can find out about
+// a conflict with ConflictFromContext after the fact
+func WithConflictTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, conflictContextKey{}, &contextConflict{})
+}
+
+// ConflictFromContext returns the most recent conflict detected by a statement using a context returned by
+// WithConflictTracking (or derived from one), reporting false if there has not been one
+func ConflictFromContext(ctx context.Context) (ConflictInfo, bool) {
+	conflict, ok := ctx.Value(conflictContextKey{}).(*contextConflict)
+	if !ok {
+		return ConflictInfo{}, false
+	}
+
+	conflict.Lock()
+	defer conflict.Unlock()
+	return conflict.info, conflict.detected
+}
+
+// reportConflict logs the conflict, records it in the statement's context and notifies registered callbacks of it
+func reportConflict(stmt *gorm.Statement, err *ConflictError) {
+	loggerFor(stmt.DB).Warnf("optimistic: %s conflicted: %v", err.Operation, err)
+
+	if stmt.Context != nil {
+		if conflict, ok := stmt.Context.Value(conflictContextKey{}).(*contextConflict); ok {
+			conflict.Lock()
+			conflict.info, conflict.detected = err.Info(), true
+			conflict.Unlock()
+		}
+	}
+
+	conflictCallbacks.RLock()
+	registered := conflictCallbacks.registered
+	conflictCallbacks.RUnlock()
+
+	if len(registered) == 0 {
+		return
+	}
+
+	ctx := stmt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	info := err.Info()
+	for _, callback := range registered {
+		(*callback)(ctx, info)
+	}
+}
+
+// panicOnConflict panics with a description of the conflict if the Plugin is configured to, rather than leaving the
+// conflict to be returned
+func panicOnConflict(tx *gorm.DB, err *ConflictError) {
+	if plugin, ok := installedPlugin(tx); !ok || !plugin.opts.PanicOnConflict {
+		return
+	}
+
+	expected := fmt.Sprintf("version %d", err.ExpectedVersion)
+	if err.ExpectedToken != "" {
+		expected = err.ExpectedToken
+	}
+	panic(fmt.Sprintf("optimistic: unhandled conflict: %s of %s with primary key %v expected %s", err.Operation,
+		err.Table, err.PrimaryKey, expected))
+}
+
+// ConflictErrorFactory makes the error returned in place of a ConflictError for conflicts on a table, see
+// SetConflictErrorFactory
+type ConflictErrorFactory func(info ConflictInfo) error
+
+var conflictErrorFactories = struct {
+	sync.RWMutex
+	byTable map[string]ConflictErrorFactory
+}{
+	byTable: map[string]ConflictErrorFactory{},
+}
+
+// SetConflictErrorFactory makes writes that conflict on the given table fail with the error made by factory rather
+// than a ConflictError, e.g. to return a domain specific error to API clients. The error still satisfies IsConflict,
+// and errors.As can still find the ConflictError it replaced, so retries and Transaction treat it as they would the
+// conflict. Conflicts on other tables, and those returned by BulkUpdate, are unaffected. Setting a nil factory restores
+// the default for the table, as does a factory returning nil for a conflict
+func SetConflictErrorFactory(table string, factory ConflictErrorFactory) {
+	conflictErrorFactories.Lock()
+	defer conflictErrorFactories.Unlock()
+
+	if factory == nil {
+		delete(conflictErrorFactories.byTable, table)
+	} else {
+		conflictErrorFactories.byTable[table] = factory
+	}
+}
+
+// tableConflictError returns the error a write that detected the conflict fails with, which is the conflict itself
+// unless a factory has been set for its table
+func tableConflictError(conflict *ConflictError) error {
+	conflictErrorFactories.RLock()
+	factory, ok := conflictErrorFactories.byTable[conflict.Table]
+	conflictErrorFactories.RUnlock()
+
+	if !ok {
+		return conflict
+	}
+
+	err := factory(conflict.Info())
+	if err == nil {
+		return conflict
+	}
+	return &customConflictError{err: err, conflict: conflict}
+}
+
+// customConflictError is the error made by a ConflictErrorFactory, which satisfies IsConflict whatever the factory
+// returned
+type customConflictError struct {
+	err      error
+	conflict *ConflictError
+}
+
+func (e *customConflictError) Error() string {
+	return e.err.Error()
+}
+
+func (e *customConflictError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is ErrConcurrentModification
+func (e *customConflictError) Is(target error) bool {
+	return target == ErrConcurrentModification
+}
+
+// StatusCode returns the HTTP status code of the error the factory made if it has one, or else that of the conflict
+func (e *customConflictError) StatusCode() int {
+	if coded, ok := e.err.(interface{ StatusCode() int }); ok {
+		return coded.StatusCode()
+	}
+	return e.conflict.StatusCode()
+}
+
+// As finds the ConflictError the error replaced, for errors.As
+func (e *customConflictError) As(target interface{}) bool {
+	if conflict, ok := target.(**ConflictError); ok {
+		*conflict = e.conflict
+		return true
+	}
+	return false
+}
+
+// vetoConflict gives the model currently being processed by the statement the chance to veto the conflict, if it
+// implements ConflictVetoer
+func vetoConflict(stmt *gorm.Statement, conflict error) error {
+	vetoer, ok := currentModel(stmt).(ConflictVetoer)
+	if !ok {
+		return conflict
+	}
+
+	ctx := stmt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	proceed, err := vetoer.OnConflictDetected(ctx)
+	switch {
+	case err != nil:
+		return err
+	case proceed:
+		return nil
+	default:
+		return conflict
+	}
+}
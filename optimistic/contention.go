@@ -0,0 +1,65 @@
+package optimistic
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HighContentionInfo describes a conflicting write to a row that had been written more times since the model was read
+// than the Plugin's HighContentionThreshold
+type HighContentionInfo struct {
+	ConflictInfo
+	// CurrentVersion is the version the row was at when the conflict was detected
+	CurrentVersion uint64
+	// Gap is how many versions the row had moved on by since the model was read, i.e. CurrentVersion less
+	// ExpectedVersion
+	Gap uint64
+}
+
+// HighContentionCallback is called when a conflicting write finds the row's version has moved on by more than the
+// Plugin's HighContentionThreshold
+type HighContentionCallback func(ctx context.Context, info HighContentionInfo)
+
+// checkContention calls the Plugin's OnHighContention callback if the row the conflicting write was made to has moved
+// on by more versions than its HighContentionThreshold. The conflict itself is handled as usual whether or not it does,
+// and models locked by something other than a version number the package writes are never checked
+func checkContention(tx *gorm.DB, l lock, conflict *ConflictError) {
+	plugin, ok := installedPlugin(tx)
+	if !ok || plugin.opts.HighContentionThreshold == 0 || plugin.opts.OnHighContention == nil {
+		return
+	}
+	if conflict.ExpectedToken != "" || isAdvancedByDatabase(l) {
+		return
+	}
+
+	conditions, ok := primaryKeyConditions(tx.Statement)
+	if !ok {
+		return
+	}
+
+	// the current version is only wanted for telemetry, so failing to read it (say, if the row has since been deleted)
+	// leaves the conflict to be reported as usual
+	var current uint64
+	err := tx.Session(&gorm.Session{NewDB: true}).Table(tx.Statement.Table).Select(l.lockColumn(tx.Statement)).
+		Where(clause.And(conditions...)).Row().Scan(&current)
+	if err != nil || current <= conflict.ExpectedVersion {
+		return
+	}
+
+	gap := current - conflict.ExpectedVersion
+	if gap <= plugin.opts.HighContentionThreshold {
+		return
+	}
+
+	ctx := tx.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	plugin.opts.OnHighContention(ctx, HighContentionInfo{
+		ConflictInfo:   conflict.Info(),
+		CurrentVersion: current,
+		Gap:            gap,
+	})
+}
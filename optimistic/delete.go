@@ -0,0 +1,37 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// PreDeleteValidator can be implemented by models to refuse to be deleted, e.g. depending on the version they were read
+// at. ValidateDelete is called before every guarded soft or hard delete of the model, once the delete has been
+// guarded, and returning an error aborts the delete with that error
+type PreDeleteValidator interface {
+	ValidateDelete(tx *gorm.DB) error
+}
+
+// validateDelete gives the model currently being processed by the statement the chance to refuse to be deleted, if it
+// implements PreDeleteValidator. If it refuses, the model's in-memory lock value is moved back to previous, since the
+// delete will not write the value the guard moved it on to
+func validateDelete(tx *gorm.DB, l lock, previous interface{}) error {
+	validator, ok := currentModel(tx.Statement).(PreDeleteValidator)
+	if !ok {
+		return nil
+	}
+
+	if err := validator.ValidateDelete(tx); err != nil {
+		restoreLockValue(l, previous)
+		return err
+	}
+
+	return nil
+}
+
+// restoreLockValue moves the model's in-memory lock value back to previous, for deletes that will not write the value
+// it was moved on to
+func restoreLockValue(l lock, previous interface{}) {
+	reflect.ValueOf(l.currentValuePtr()).Elem().Set(reflect.ValueOf(previous))
+}
@@ -0,0 +1,78 @@
+package optimistic
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FieldDiff holds the differing values of one of a model's fields, see Diff
+type FieldDiff struct {
+	// Local is the value of the field in the model
+	Local interface{}
+	// Remote is the value of the field in the model's row in the database
+	Remote interface{}
+}
+
+// Diff loads the model's row from the database by its primary key and reports which of the model's fields hold
+// different values to it, keyed by field name, e.g. to show a user what changed underneath them after a conflict. The
+// model itself is left unchanged. The lock field is compared using the value the model was read at, so its FieldDiff
+// gives how far the row has moved on since then. It returns gorm.ErrRecordNotFound if the row no longer exists
+func Diff(tx *gorm.DB, model interface{}) (map[string]FieldDiff, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	local := reflect.Indirect(reflect.ValueOf(model))
+
+	remoteModel := reflect.New(stmt.Schema.ModelType)
+	if err := takeByPrimaryKey(tableSession(tx), model, remoteModel.Interface()); err != nil {
+		return nil, err
+	}
+	remote := remoteModel.Elem()
+
+	var lockColumn string
+	l, locked := model.(lock)
+	if locked {
+		stmt.ReflectValue = local
+		lockColumn = l.lockColumn(stmt)
+	}
+
+	diffs := map[string]FieldDiff{}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.DataType == "" {
+			// not stored in a column of the model's table, e.g. an association
+			continue
+		}
+
+		localValue := field.ReflectValueOf(local).Interface()
+		if locked && field.DBName == lockColumn {
+			localValue = l.readValue()
+		}
+		remoteValue := field.ReflectValueOf(remote).Interface()
+
+		if !fieldValuesEqual(localValue, remoteValue) {
+			diffs[field.Name] = FieldDiff{Local: localValue, Remote: remoteValue}
+		}
+	}
+
+	return diffs, nil
+}
+
+// fieldValuesEqual reports whether two values of a field are the same, comparing times by the instant they represent
+// since the database may not give them the same location as the model holds
+func fieldValuesEqual(a, b interface{}) bool {
+	switch at := a.(type) {
+	case time.Time:
+		if bt, ok := b.(time.Time); ok {
+			return at.Equal(bt)
+		}
+	case *time.Time:
+		if bt, ok := b.(*time.Time); ok {
+			return at == bt || (at != nil && bt != nil && at.Equal(*bt))
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
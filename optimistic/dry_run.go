@@ -0,0 +1,94 @@
+package optimistic
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const (
+	dryRunKey = "optimistic:dry_run"
+)
+
+// DryRunResult describes whether an update or delete made in a DryRun session would have conflicted
+type DryRunResult struct {
+	// Operation is the kind of write that was checked
+	Operation Operation
+	// Table is the name of the table the write would have been applied to
+	Table string
+	// PrimaryKey is the primary key of the written model, composite primary keys are given as a []interface{}
+	PrimaryKey interface{}
+	// Conflict describes the conflict the write would have failed with, or is nil if it would have succeeded
+	Conflict *ConflictError
+	// Err is the error that prevented the write from being checked, e.g. gorm.ErrRecordNotFound if the row no longer
+	// exists
+	Err error
+}
+
+// DryRunReport collects the results of checking the writes made in a DryRun session
+type DryRunReport struct {
+	mu      sync.Mutex
+	results []DryRunResult
+}
+
+// Results returns the result of checking each write, in the order the writes were made
+func (r *DryRunReport) Results() []DryRunResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]DryRunResult(nil), r.results...)
+}
+
+// Conflicts returns the conflicts the writes would have failed with
+func (r *DryRunReport) Conflicts() []*ConflictError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var conflicts []*ConflictError
+	for _, result := range r.results {
+		if result.Conflict != nil {
+			conflicts = append(conflicts, result.Conflict)
+		}
+	}
+	return conflicts
+}
+
+// DryRun returns a session in which updates and deletes are checked for concurrent modification but not made, using
+// GORM's dry run mode, e.g. to validate a migration's pending writes. Each write instead compares the lock value of
+// the model's row with the one the model was read at by selecting it, leaving the model unchanged, and records the
+// outcome in the returned report. As with GORM's dry run mode, nothing else is executed in the session either, so
+// models should be read outside of it
+func DryRun(db *gorm.DB) (*gorm.DB, *DryRunReport) {
+	report := &DryRunReport{}
+	return db.Set(dryRunKey, report).Session(&gorm.Session{DryRun: true}), report
+}
+
+// dryRunReport returns the report of the DryRun session the statement belongs to, if any
+func dryRunReport(stmt *gorm.Statement) (*DryRunReport, bool) {
+	report, ok := stmt.Settings.Load(dryRunKey)
+	if !ok {
+		return nil, false
+	}
+	return report.(*DryRunReport), true
+}
+
+// check records whether the write would conflict
+func (r *DryRunReport) check(tx *gorm.DB, l lock, op Operation) {
+	stmt := tx.Statement
+	result := DryRunResult{
+		Operation:  op,
+		Table:      stmt.Table,
+		PrimaryKey: primaryKeyOf(stmt),
+	}
+
+	matches, err := lockMatches(tx, stmt, l)
+	if err != nil {
+		result.Err = err
+	} else if !matches {
+		result.Conflict = newConflictError(stmt, op)
+		l.describe(result.Conflict)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
@@ -0,0 +1,221 @@
+package optimistic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrConcurrentModification is returned when concurrent modification is detected during an Update or Delete operation
+// on a Versioned model
+var ErrConcurrentModification = errors.New("concurrent modification detected")
+
+// ErrVersionOverflow is returned when a Versioned model cannot be updated or deleted because its version is already the
+// largest that can be represented, rather than wrapping around to a version that may collide with an earlier one
+var ErrVersionOverflow = errors.New("version cannot be incremented any further")
+
+// ErrUnknownReadVersion is returned when updating a model that was never read from the database (say, one constructed
+// by hand), since there is no version to check the database against - load the model first, set the version it was read
+// at with SetReadVersion or, for writes that should not be checked, use WithoutVersionCheck
+var ErrUnknownReadVersion = errors.New("model must be read before it is updated, its read version is unknown")
+
+// ErrVersionUnchanged is returned when a write scoped with ExplicitVersion is made without changing the model's version
+var ErrVersionUnchanged = errors.New("explicit version must differ from the version the model was read at")
+
+// ErrNegativeVersion is returned when a model with a signed version field cannot be updated or deleted because its
+// version is negative, which this package never writes and so cannot have been moved on from safely
+var ErrNegativeVersion = errors.New("version is negative")
+
+// ErrUnaddressableModel is returned by the Plugin when a locked model is written by value rather than by pointer (e.g.
+// db.Updates(model) rather than db.Updates(&model)), since its new version could not be written back to it
+var ErrUnaddressableModel = errors.New("model must be passed by pointer so that its version can be written")
+
+// ErrNoVersionNumber is returned by writes scoped with WithExpectedVersion to models locked by something other than a
+// version number
+var ErrNoVersionNumber = errors.New("model is not locked by a version number")
+
+// ErrVersionBehind is returned when a write scoped with AllowVersionAhead is made with the model's version set behind
+// the version it was read at
+var ErrVersionBehind = errors.New("version must not be behind the version the model was read at")
+
+// ErrInvalidVersionIncrement is returned by updates scoped with WithVersionIncrement given a delta of zero, which would
+// leave the version unchanged
+var ErrInvalidVersionIncrement = errors.New("version increment must be at least one")
+
+// ErrMalformedVersionToken is returned by ParseVersionToken when given a token that MarshalVersionToken did not produce
+var ErrMalformedVersionToken = errors.New("malformed version token")
+
+// ErrVersionColumnMissing is satisfied by the *MissingVersionColumnError returned when a locked model's table does not
+// have its lock column
+var ErrVersionColumnMissing = errors.New("lock column is missing")
+
+// Operation identifies the kind of write that detected a concurrent modification
+type Operation int
+
+const (
+	// OperationUpdate is an update
+	OperationUpdate Operation = iota
+	// OperationSoftDelete is a delete of a model with a gorm.DeletedAt field (or another soft delete type, such as
+	// those of gorm.io/plugin/soft_delete), which only marks its row as deleted
+	OperationSoftDelete
+	// OperationHardDelete is a delete removing a row, i.e. an Unscoped delete or a delete of a model that cannot be
+	// soft deleted
+	OperationHardDelete
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OperationUpdate:
+		return "update"
+	case OperationSoftDelete:
+		return "soft delete"
+	case OperationHardDelete:
+		return "hard delete"
+	default:
+		return fmt.Sprintf("Operation(%d)", int(op))
+	}
+}
+
+// pastTense describes the operation having been performed, for log messages
+func (op Operation) pastTense() string {
+	switch op {
+	case OperationUpdate:
+		return "updated"
+	case OperationSoftDelete:
+		return "soft deleted"
+	case OperationHardDelete:
+		return "hard deleted"
+	default:
+		return op.String()
+	}
+}
+
+// ConflictError describes a concurrent modification detected during an Update or Delete operation on a Versioned
+// model, it satisfies errors.Is(err, ErrConcurrentModification)
+type ConflictError struct {
+	// Operation is the kind of write that detected the conflict
+	Operation Operation
+	// Table is the name of the table the conflicting operation was applied to
+	Table string
+	// PrimaryKey is the primary key of the conflicting model, composite primary keys are given as a []interface{}
+	PrimaryKey interface{}
+	// ExpectedVersion is the version the model was read at, which the database no longer holds
+	ExpectedVersion uint64
+	// ExpectedToken is the token the model was read at, for models locked by something other than a version number
+	ExpectedToken string
+
+	// ifMatch records that the write was made for a request handled by ETagMiddleware
+	ifMatch bool
+}
+
+func (e *ConflictError) Error() string {
+	if e.ExpectedToken != "" {
+		return fmt.Sprintf("%v: %s with primary key %v is no longer at %s", ErrConcurrentModification, e.Table,
+			e.PrimaryKey, e.ExpectedToken)
+	}
+
+	return fmt.Sprintf("%v: %s with primary key %v is no longer at version %d", ErrConcurrentModification, e.Table,
+		e.PrimaryKey, e.ExpectedVersion)
+}
+
+// Is reports whether target is ErrConcurrentModification
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConcurrentModification
+}
+
+// StatusCode returns the HTTP status code of the conflict, for frameworks mapping errors to responses by looking for a
+// StatusCode method. It is 409 Conflict, or 412 Precondition Failed for writes made using the context of a request
+// handled by ETagMiddleware, where the conflict means the request's If-Match header no longer holds
+func (e *ConflictError) StatusCode() int {
+	if e.ifMatch {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
+
+// conflictJSON is the JSON representation of a ConflictError
+type conflictJSON struct {
+	Error           string      `json:"error"`
+	Table           string      `json:"table,omitempty"`
+	PrimaryKey      interface{} `json:"pk,omitempty"`
+	ExpectedVersion *uint64     `json:"expected_version,omitempty"`
+	ExpectedToken   string      `json:"expected_token,omitempty"`
+	Operation       string      `json:"operation"`
+}
+
+// MarshalJSON represents the conflict as e.g. {"error":"conflict","table":"models","pk":1,"expected_version":3,
+// "operation":"update"}, for services logging errors as JSON. Fields that are not set are left out, and conflicts on
+// models locked by something other than a version number give their "expected_token" in place of "expected_version"
+func (e *ConflictError) MarshalJSON() ([]byte, error) {
+	value := conflictJSON{
+		Error:      "conflict",
+		Table:      e.Table,
+		PrimaryKey: e.PrimaryKey,
+		Operation:  e.Operation.String(),
+	}
+	if e.ExpectedToken != "" {
+		value.ExpectedToken = e.ExpectedToken
+	} else {
+		value.ExpectedVersion = &e.ExpectedVersion
+	}
+	return json.Marshal(value)
+}
+
+// MissingRowError is returned when an Update or Delete operation on a locked model matches no rows because the model's
+// row no longer exists (or has been soft deleted), rather than because it has been modified concurrently. It satisfies
+// errors.Is(err, gorm.ErrRecordNotFound), but is not a conflict, so retrying the operation would not help
+type MissingRowError struct {
+	// Operation is the kind of write that found the row missing
+	Operation Operation
+	// Table is the name of the table the operation was applied to
+	Table string
+	// PrimaryKey is the primary key of the missing model, composite primary keys are given as a []interface{}
+	PrimaryKey interface{}
+}
+
+func (e *MissingRowError) Error() string {
+	return fmt.Sprintf("%v: %s of %s with primary key %v", gorm.ErrRecordNotFound, e.Operation, e.Table, e.PrimaryKey)
+}
+
+// Is reports whether target is gorm.ErrRecordNotFound
+func (e *MissingRowError) Is(target error) bool {
+	return target == gorm.ErrRecordNotFound
+}
+
+// MissingVersionColumnError is returned by ValidateVersionColumns when the tables of locked models do not have their
+// lock columns, e.g. because they have not been migrated since the lock was added, and by writes made through the
+// Plugin that the database failed for the same reason. It satisfies errors.Is(err, ErrVersionColumnMissing)
+type MissingVersionColumnError struct {
+	// Columns are the missing columns, each given as the table name and column name separated by a dot
+	Columns []string
+	// Err is the error the database failed the write with, which is nil for errors returned by ValidateVersionColumns
+	Err error
+}
+
+func (e *MissingVersionColumnError) Error() string {
+	message := fmt.Sprintf("missing lock columns (run AutoMigrate or EnsureVersionColumn): %s",
+		strings.Join(e.Columns, ", "))
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", message, e.Err)
+	}
+	return message
+}
+
+// Is reports whether target is ErrVersionColumnMissing
+func (e *MissingVersionColumnError) Is(target error) bool {
+	return target == ErrVersionColumnMissing
+}
+
+// Unwrap returns the error the database failed the write with, if any
+func (e *MissingVersionColumnError) Unwrap() error {
+	return e.Err
+}
+
+// IsConflict reports whether err is, or wraps, ErrConcurrentModification
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConcurrentModification)
+}
@@ -0,0 +1,129 @@
+package optimistic
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// defaultEtagColumn is the name of the ETag column, unless renamed using GORM's embeddedPrefix tag
+const defaultEtagColumn = "etag"
+
+var etaggedType = reflect.TypeOf(Etagged{})
+
+// Etagged can be embedded in a GORM model to add optimistic locking using an opaque random token as the lock, rather
+// than a version number, making it suitable for use as an HTTP ETag without revealing how often the model is written.
+// The token is a random UUID stored in a column named "etag"
+type Etagged struct {
+	Etag     string `gorm:"not null;default:''"`
+	readEtag string `gorm:"-"`
+}
+
+// CurrentEtag returns the ETag of the model as it is in memory, which after an update or delete is the new ETag written
+// to the database
+func (e *Etagged) CurrentEtag() string {
+	return e.Etag
+}
+
+// ReadEtag returns the ETag this model was last read (or created) at, which is what updates and deletes check the
+// database against. It is empty for a model that has never been read or created
+func (e *Etagged) ReadEtag() string {
+	return e.readEtag
+}
+
+// BeforeCreate gives newly created models an initial ETag
+func (e *Etagged) BeforeCreate(tx *gorm.DB) error {
+	if e.Etag == "" {
+		etag, err := newEtag()
+		if err != nil {
+			return err
+		}
+		e.Etag = etag
+	}
+
+	return nil
+}
+
+// BeforeUpdate ensures that updates to an Etagged model only apply if there has not been a concurrent modification,
+// detected through the ETag, and asserts that the new object will have a new ETag
+func (e *Etagged) BeforeUpdate(tx *gorm.DB) error {
+	return beforeUpdate(tx, e)
+}
+
+// AfterUpdate detects concurrent modification issues
+func (e *Etagged) AfterUpdate(tx *gorm.DB) error {
+	return afterUpdate(tx, e)
+}
+
+// BeforeDelete ensures that deleting an Etagged model only applies if there has not been a concurrent modification,
+// detected through the ETag, and asserts that the deleted object will have a new ETag
+func (e *Etagged) BeforeDelete(tx *gorm.DB) error {
+	return beforeDelete(tx, e)
+}
+
+// AfterDelete detects concurrent modification issues
+func (e *Etagged) AfterDelete(tx *gorm.DB) error {
+	return afterDelete(tx, e)
+}
+
+// AfterCreate sets the internal read ETag to reflect the created ETag
+func (e *Etagged) AfterCreate(tx *gorm.DB) error {
+	return afterRead(tx, e)
+}
+
+// AfterFind sets the internal read ETag based on the retrieved ETag
+func (e *Etagged) AfterFind(tx *gorm.DB) error {
+	return afterRead(tx, e)
+}
+
+func (e *Etagged) lockColumn(stmt *gorm.Statement) string {
+	return embeddedColumn(stmt, etaggedType, "Etag", defaultEtagColumn)
+}
+
+func (e *Etagged) readValue() interface{} {
+	return e.readEtag
+}
+
+func (e *Etagged) currentValue() interface{} {
+	return e.Etag
+}
+
+func (e *Etagged) currentValuePtr() interface{} {
+	return &e.Etag
+}
+
+func (e *Etagged) unread() bool {
+	return e.readEtag == ""
+}
+
+func (e *Etagged) advance(stmt *gorm.Statement) (interface{}, error) {
+	etag, err := newEtag()
+	if err != nil {
+		return nil, err
+	}
+	e.Etag = etag
+	return e.Etag, nil
+}
+
+func (e *Etagged) markRead() {
+	e.readEtag = e.Etag
+}
+
+func (e *Etagged) describe(err *ConflictError) {
+	err.ExpectedToken = e.readEtag
+}
+
+// newEtag generates a random (version 4) UUID
+func newEtag() (string, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return "", fmt.Errorf("failed to generate etag: %w", err)
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
@@ -0,0 +1,75 @@
+package optimistic
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrContentLocked is returned by Exec for Hashed models, whose new content hash cannot be known without knowing what
+// a raw update writes
+var ErrContentLocked = errors.New("model is locked by its content, which a raw update cannot hash")
+
+// Exec updates the model's row with a raw SET expression, for hot paths where building the update with GORM is too
+// costly, e.g. optimistic.Exec(db, &model, "value = value + ?", 1). Like the raw SQL of GORM's Exec it skips hooks and
+// leaves every other column alone, but the update still only applies if there has not been a concurrent modification
+// and moves the lock on, as UpdateColumnVersioned does. It returns ErrNotLocked if the model does not embed one of
+// this package's lock types
+func Exec(tx *gorm.DB, model interface{}, set string, args ...interface{}) error {
+	l, ok := model.(lock)
+	if !ok {
+		return ErrNotLocked
+	}
+	if _, ok := l.(contentLock); ok {
+		return ErrContentLocked
+	}
+
+	update := tx.Model(model)
+	stmt := update.Statement
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+
+	if err := applyExpectedVersion(stmt, l); err != nil {
+		return err
+	}
+	if l.unread() {
+		return ErrUnknownReadVersion
+	}
+	keys, ok := primaryKeyConditions(stmt)
+	if !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+
+	column := clause.Column{Name: l.lockColumn(stmt)}
+	sql := "UPDATE ? SET " + set
+	vars := append([]interface{}{clause.Table{Name: stmt.Table}}, args...)
+	if !isAdvancedByDatabase(l) {
+		value, err := nextLockValue(stmt, l)
+		if err != nil {
+			return err
+		}
+		sql += ", ? = ?"
+		vars = append(vars, column, value)
+	}
+	// GORM cannot build conditions given as the variables of raw SQL, so they are written out
+	sql += " WHERE "
+	for _, key := range keys {
+		eq := key.(clause.Eq)
+		sql += "? = ? AND "
+		vars = append(vars, eq.Column, eq.Value)
+	}
+	sql += "? = ?"
+	vars = append(vars, column, l.readValue())
+
+	result := tx.Session(&gorm.Session{NewDB: true}).Exec(sql, vars...)
+	if result.Error != nil {
+		return result.Error
+	}
+	update.RowsAffected = result.RowsAffected
+
+	return ensureRowsAffected(update, l, OperationUpdate)
+}
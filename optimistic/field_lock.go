@@ -0,0 +1,132 @@
+package optimistic
+
+import (
+	"math"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// lockFieldTag is the GORM tag setting that marks an integer field as the model's version, as an alternative
+// to embedding Versioned, e.g. `gorm:"optimisticlock"`. GORM upper cases tag setting names when parsing them
+const lockFieldTag = "OPTIMISTICLOCK"
+
+// fieldLock guards writes to a model using a field tagged with gorm:"optimisticlock", which the Plugin looks for in
+// models that do not embed a lock type. With nowhere to remember the version the model was read at, the field's value
+// when a write is made is taken to be that version, so it should not be modified other than by this package. Signed
+// fields hold their versions in read as their two's complement, so that negative values survive the round trip
+type fieldLock struct {
+	field  *schema.Field
+	value  reflect.Value
+	read   uint64
+	signed bool
+}
+
+// lockField returns the field of the schema tagged as its version, if any
+func lockField(s *schema.Schema) (*schema.Field, bool) {
+	for _, field := range s.Fields {
+		if _, ok := field.TagSettings[lockFieldTag]; !ok || field.DBName == "" {
+			continue
+		}
+
+		switch field.FieldType.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return field, true
+		}
+	}
+
+	return nil, false
+}
+
+// newFieldLock creates a fieldLock for the version field of a model. Callbacks running after a write made by the
+// statement are given the version the write was guarded on, however far the write moved the version on, otherwise the
+// field's value is taken to be the version it was read at. The statement may be nil
+func newFieldLock(field *schema.Field, model reflect.Value, stmt *gorm.Statement) *fieldLock {
+	value := field.ReflectValueOf(model)
+	l := &fieldLock{
+		field: field,
+		value: value,
+	}
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		l.signed = true
+	}
+	l.markRead()
+	if stmt != nil {
+		if read, ok := guardedFieldRead(stmt, value); ok {
+			l.read = read
+		}
+	}
+	return l
+}
+
+// negative reports whether a signed field was read at a negative version
+func (l *fieldLock) negative() bool {
+	return l.signed && int64(l.read) < 0
+}
+
+// set writes the version into the field
+func (l *fieldLock) set(version uint64) {
+	if l.signed {
+		l.value.SetInt(int64(version))
+	} else {
+		l.value.SetUint(version)
+	}
+}
+
+// reset moves the in-memory version back to the version the write started from, so that a model that failed to be
+// written does not appear to be at the version it would have been written at
+func (l *fieldLock) reset() {
+	l.set(l.read)
+}
+
+func (l *fieldLock) lockColumn(stmt *gorm.Statement) string {
+	return l.field.DBName
+}
+
+func (l *fieldLock) readValue() interface{} {
+	if l.signed {
+		return int64(l.read)
+	}
+	return l.read
+}
+
+func (l *fieldLock) currentValue() interface{} {
+	return l.value.Interface()
+}
+
+func (l *fieldLock) currentValuePtr() interface{} {
+	return l.value.Addr().Interface()
+}
+
+func (l *fieldLock) unread() bool {
+	return l.read == 0
+}
+
+func (l *fieldLock) advance(stmt *gorm.Statement) (interface{}, error) {
+	switch {
+	case l.negative():
+		return nil, ErrNegativeVersion
+	case l.signed && (l.read == math.MaxInt64 || l.value.OverflowInt(int64(l.read)+1)):
+		return nil, ErrVersionOverflow
+	case !l.signed && (l.read == math.MaxUint64 || l.value.OverflowUint(l.read+1)):
+		return nil, ErrVersionOverflow
+	}
+
+	l.set(l.read + 1)
+	return l.value.Interface(), nil
+}
+
+func (l *fieldLock) markRead() {
+	if l.signed {
+		l.read = uint64(l.value.Int())
+	} else {
+		l.read = l.value.Uint()
+	}
+}
+
+func (l *fieldLock) describe(err *ConflictError) {
+	err.ExpectedVersion = l.read
+}
@@ -0,0 +1,82 @@
+package optimistic
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+const (
+	guardKey = "optimistic:guard"
+)
+
+// writeGuard records how guardWrite guarded a write, for DescribeGuard
+type writeGuard struct {
+	column   string
+	expected interface{}
+	// unchecked and forced record that the write was made WithoutVersionCheck or with ForceUpdate
+	unchecked bool
+	forced    bool
+	// advanced records that the write moves the lock on to value, which is unset for locks moved on by the database
+	advanced bool
+	value    interface{}
+	// assigned records that the new lock value is assigned however the statement's SET clause is replaced, so a soft
+	// delete writes it without a follow-up update
+	assigned bool
+	// fieldReads holds the versions the field locks of the statement's models were guarded on, by the address of their
+	// field, since the models have nowhere to remember them once their fields are moved on. It is shared by the guards
+	// of every model a statement writes
+	fieldReads map[uintptr]uint64
+}
+
+// recordFieldRead records the version a field lock was guarded on, carrying over those recorded for the statement's
+// other models
+func (g *writeGuard) recordFieldRead(stmt *gorm.Statement, l *fieldLock) {
+	if previous, ok := stmt.Settings.Load(guardKey); ok && previous.(*writeGuard).fieldReads != nil {
+		g.fieldReads = previous.(*writeGuard).fieldReads
+	} else {
+		g.fieldReads = map[uintptr]uint64{}
+	}
+	g.fieldReads[l.value.Addr().Pointer()] = l.read
+}
+
+// guardedFieldRead returns the version the statement's write guarded the field lock of the model on, if it did
+func guardedFieldRead(stmt *gorm.Statement, value reflect.Value) (uint64, bool) {
+	loaded, ok := stmt.Settings.Load(guardKey)
+	if !ok || !value.CanAddr() {
+		return 0, false
+	}
+	read, ok := loaded.(*writeGuard).fieldReads[value.Addr().Pointer()]
+	return read, ok
+}
+
+func (g *writeGuard) String() string {
+	var description string
+	switch {
+	case g.unchecked:
+		description = "no version check"
+	case g.forced:
+		description = fmt.Sprintf("%s = %v (forced, the value currently in the database)", g.column, g.expected)
+	default:
+		description = fmt.Sprintf("%s = %v", g.column, g.expected)
+	}
+
+	if g.advanced {
+		description += fmt.Sprintf(", setting %s to %v", g.column, g.value)
+	}
+	return description
+}
+
+// DescribeGuard describes the condition and lock value the update or delete made by the statement was guarded with,
+// e.g. "version = 3, setting version to 4", for diagnosing writes that unexpectedly conflict. It can be used without
+// making the write by building it in one of GORM's dry run sessions, e.g.
+// optimistic.DescribeGuard(db.Session(&gorm.Session{DryRun: true}).Updates(&model)), and returns an empty string if the
+// write was not guarded (say, because its model is not locked)
+func DescribeGuard(tx *gorm.DB) string {
+	guard, ok := tx.Statement.Settings.Load(guardKey)
+	if !ok {
+		return ""
+	}
+	return guard.(*writeGuard).String()
+}
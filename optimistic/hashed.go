@@ -0,0 +1,180 @@
+package optimistic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// defaultHashColumn is the name of the content hash column, unless renamed using GORM's embeddedPrefix tag
+const defaultHashColumn = "content_hash"
+
+// hashTagKey is the struct tag used to choose which fields of a Hashed model participate in its content hash
+const hashTagKey = "optimistic"
+
+var hashedType = reflect.TypeOf(Hashed{})
+
+// Hashed can be embedded in a GORM model to add optimistic locking using a hash of the model's content as the lock,
+// rather than a version number. The hash is stored in a column named "content_hash", and is omitted from JSON.
+//
+// By default every column participates in the hash except primary keys, timestamps GORM maintains automatically
+// (CreatedAt and UpdatedAt), soft delete columns such as DeletedAt and the hash itself. Fields can be excluded with an
+// `optimistic:"-"` tag, or if any field is tagged `optimistic:"hash"` then only fields tagged that way participate.
+type Hashed struct {
+	ContentHash string `gorm:"not null;default:''" json:"-"`
+	readHash    string `gorm:"-"`
+}
+
+// ReadHash returns the content hash this model was last read (or created) at, which is what updates and deletes check
+// the database against. It is empty for a model that has never been read or created
+func (h *Hashed) ReadHash() string {
+	return h.readHash
+}
+
+// BeforeCreate computes the content hash of newly created models
+func (h *Hashed) BeforeCreate(tx *gorm.DB) error {
+	if h.ContentHash == "" {
+		hash, err := contentHash(tx.Statement, nil)
+		if err != nil {
+			return err
+		}
+		h.ContentHash = hash
+	}
+
+	return nil
+}
+
+// BeforeUpdate ensures that updates to a Hashed model only apply if there has not been a concurrent modification,
+// detected through the content hash, and asserts that the new object will have the hash of its new content
+func (h *Hashed) BeforeUpdate(tx *gorm.DB) error {
+	return beforeUpdate(tx, h)
+}
+
+// AfterUpdate detects concurrent modification issues
+func (h *Hashed) AfterUpdate(tx *gorm.DB) error {
+	return afterUpdate(tx, h)
+}
+
+// BeforeDelete ensures that deleting a Hashed model only applies if there has not been a concurrent modification,
+// detected through the content hash
+func (h *Hashed) BeforeDelete(tx *gorm.DB) error {
+	return beforeDelete(tx, h)
+}
+
+// AfterDelete detects concurrent modification issues
+func (h *Hashed) AfterDelete(tx *gorm.DB) error {
+	return afterDelete(tx, h)
+}
+
+// AfterCreate sets the internal read hash to reflect the created content
+func (h *Hashed) AfterCreate(tx *gorm.DB) error {
+	return afterRead(tx, h)
+}
+
+// AfterFind sets the internal read hash based on the retrieved hash
+func (h *Hashed) AfterFind(tx *gorm.DB) error {
+	return afterRead(tx, h)
+}
+
+func (h *Hashed) lockColumn(stmt *gorm.Statement) string {
+	return embeddedColumn(stmt, hashedType, "ContentHash", defaultHashColumn)
+}
+
+func (h *Hashed) readValue() interface{} {
+	return h.readHash
+}
+
+func (h *Hashed) currentValue() interface{} {
+	return h.ContentHash
+}
+
+func (h *Hashed) currentValuePtr() interface{} {
+	return &h.ContentHash
+}
+
+func (h *Hashed) unread() bool {
+	return h.readHash == ""
+}
+
+func (h *Hashed) advance(stmt *gorm.Statement) (interface{}, error) {
+	updates, _ := stmt.Dest.(map[string]interface{})
+	hash, err := contentHash(stmt, updates)
+	if err != nil {
+		return nil, err
+	}
+	h.ContentHash = hash
+	return h.ContentHash, nil
+}
+
+func (h *Hashed) markRead() {
+	h.readHash = h.ContentHash
+}
+
+func (h *Hashed) describe(err *ConflictError) {
+	err.ExpectedToken = h.readHash
+}
+
+func (h *Hashed) contentLocked() {}
+
+// contentHash hashes the participating fields of the model currently being processed by the statement, taking values
+// from updates (keyed by field or column name) in preference to the model where present
+func contentHash(stmt *gorm.Statement, updates map[string]interface{}) (string, error) {
+	rv := currentReflectValue(stmt)
+	if stmt.Schema == nil || !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return "", nil
+	}
+
+	var values []interface{}
+	for _, field := range hashedFields(stmt.Schema) {
+		value, ok := updates[field.Name]
+		if !ok {
+			value, ok = updates[field.DBName]
+		}
+		if !ok {
+			value, _ = field.ValueOf(rv)
+		}
+		values = append(values, field.DBName, value)
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashedFields returns the fields of the schema that participate in the content hash
+func hashedFields(s *schema.Schema) []*schema.Field {
+	var candidates []*schema.Field
+	var tagged []*schema.Field
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+			continue
+		}
+		if field.OwnerSchema != nil && field.OwnerSchema.ModelType == hashedType {
+			continue
+		}
+		if isSoftDeleteField(field) {
+			continue
+		}
+
+		switch field.Tag.Get(hashTagKey) {
+		case "-":
+			continue
+		case "hash":
+			tagged = append(tagged, field)
+		}
+		candidates = append(candidates, field)
+	}
+
+	if len(tagged) > 0 {
+		return tagged
+	}
+	return candidates
+}
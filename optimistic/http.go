@@ -0,0 +1,131 @@
+package optimistic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errIfMatchUnsupported is reported for If-Match headers that cannot be satisfied by a single version
+var errIfMatchUnsupported = errors.New("If-Match must be * or a single ETag")
+
+type ifMatchContextKey struct{}
+
+// WriteHandler handles a request that writes a Versioned model, returning the written model so that its ETag can be
+// sent in the response
+type WriteHandler func(w http.ResponseWriter, r *http.Request) (*Versioned, error)
+
+// ETag returns the (strong) HTTP entity tag for the current version of the model
+func ETag(v *Versioned) string {
+	return `"` + v.MarshalVersionToken() + `"`
+}
+
+// ETagMiddleware adapts a WriteHandler into an http.Handler implementing If-Match preconditions. Before calling the
+// handler it parses the request's If-Match header, which the handler applies to the model it writes using
+// ApplyIfMatch. A handler failing due to concurrent modification results in a 412 Precondition Failed response, and
+// any other error in a 500 Internal Server Error. Otherwise the ETag of the written model is added to the handler's
+// response, which is buffered until the handler returns so that it can be discarded if the handler fails
+func ETagMiddleware(handler WriteHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, ok, err := parseIfMatch(r.Header.Values("If-Match"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), ifMatchContextKey{}, version))
+		}
+
+		response := &bufferedResponse{header: http.Header{}}
+		model, err := handler(response, r)
+		if IsConflict(err) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		for key, values := range response.header {
+			w.Header()[key] = values
+		}
+		if model != nil {
+			w.Header().Set("ETag", ETag(model))
+		}
+		if response.status != 0 {
+			w.WriteHeader(response.status)
+		}
+		_, _ = w.Write(response.body.Bytes())
+	})
+}
+
+// ApplyIfMatch sets the read version of the model to the version in the If-Match header of a request handled by
+// ETagMiddleware, so that writing the model fails if it is no longer at the version the client holds. It reports
+// whether the request had such a header, leaving the read version unchanged if not
+func ApplyIfMatch(r *http.Request, v *Versioned) bool {
+	version, ok := r.Context().Value(ifMatchContextKey{}).(uint64)
+	if ok {
+		v.SetReadVersion(version)
+	}
+	return ok
+}
+
+// parseIfMatch returns the version in an If-Match header, reporting false if there is no header or it matches any
+// version
+func parseIfMatch(headers []string) (uint64, bool, error) {
+	var tags []string
+	for _, header := range headers {
+		for _, tag := range strings.Split(header, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	switch {
+	case len(tags) == 0:
+		return 0, false, nil
+	case len(tags) == 1 && tags[0] == "*":
+		return 0, false, nil
+	case len(tags) > 1:
+		return 0, false, errIfMatchUnsupported
+	}
+
+	// weak tags never match an If-Match precondition, and so are malformed here too
+	tag := tags[0]
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false, ErrMalformedVersionToken
+	}
+	version, err := ParseVersionToken(tag[1 : len(tag)-1])
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version, true, nil
+}
+
+// bufferedResponse holds a response until it is known whether it should be sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
@@ -0,0 +1,82 @@
+package optimistic
+
+import (
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// defaultInitialVersion is the version models are created at, unless the Plugin is configured with another
+const defaultInitialVersion = 1
+
+// versionedModelType is the type of the interface implemented by models embedding Versioned
+var versionedModelType = reflect.TypeOf((*versionedModel)(nil)).Elem()
+
+// initialVersion returns the version models written through the database are created at
+func initialVersion(db *gorm.DB) uint64 {
+	if plugin, ok := installedPlugin(db); ok && plugin.opts.InitialVersion != nil {
+		return *plugin.opts.InitialVersion
+	}
+	return defaultInitialVersion
+}
+
+// versionField returns the field holding the version number of the schema's models, which either embed Versioned or
+// have a field tagged as their version
+func versionField(stmt *gorm.Statement) (*schema.Field, bool) {
+	if reflect.PtrTo(stmt.Schema.ModelType).Implements(versionedModelType) {
+		return stmt.Schema.LookUpField(versionColumn(stmt)), true
+	}
+	return lockField(stmt.Schema)
+}
+
+// applyInitialVersion creates models without a version at the configured initial version. GORM gives such models the
+// default value of their version field, which is taken from its struct tag, so the statement is given a copy of its
+// schema in which the field's default is the initial version instead
+func (p *Plugin) applyInitialVersion(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || p.opts.InitialVersion == nil {
+		return
+	}
+
+	cached, ok := p.createSchemas.Load(stmt.Schema)
+	if !ok {
+		cached, _ = p.createSchemas.LoadOrStore(stmt.Schema, initialVersionSchema(stmt, *p.opts.InitialVersion))
+	}
+	stmt.Schema = cached.(*schema.Schema)
+}
+
+// initialVersionSchema returns a copy of the statement's schema in which the version field defaults to the given
+// version, or the schema itself if it has no version field
+func initialVersionSchema(stmt *gorm.Statement, version uint64) *schema.Schema {
+	field, ok := versionField(stmt)
+	if !ok {
+		return stmt.Schema
+	}
+
+	initial := *field
+	initial.HasDefaultValue = true
+	initial.DefaultValue = strconv.FormatUint(version, 10)
+	switch field.FieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		initial.DefaultValueInterface = int64(version)
+	default:
+		initial.DefaultValueInterface = version
+	}
+
+	copied := *stmt.Schema
+	copied.FieldsByDBName = make(map[string]*schema.Field, len(stmt.Schema.FieldsByDBName))
+	for name, f := range stmt.Schema.FieldsByDBName {
+		copied.FieldsByDBName[name] = f
+	}
+	copied.FieldsByDBName[field.DBName] = &initial
+	copied.FieldsWithDefaultDBValue = nil
+	for _, f := range stmt.Schema.FieldsWithDefaultDBValue {
+		if f != field {
+			copied.FieldsWithDefaultDBValue = append(copied.FieldsWithDefaultDBValue, f)
+		}
+	}
+
+	return &copied
+}
@@ -0,0 +1,360 @@
+package optimistic
+
+import (
+	"database/sql"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// deleteOperation returns the kind of delete the statement performs, soft deleting unless it is unscoped or its model
+// has no soft delete field (which GORM gives delete clauses to)
+func deleteOperation(stmt *gorm.Statement) Operation {
+	if stmt.Unscoped || stmt.Schema == nil || len(stmt.Schema.DeleteClauses) == 0 {
+		return OperationHardDelete
+	}
+	return OperationSoftDelete
+}
+
+// lock is implemented by each of the embeddable lock types, allowing them to share the logic of guarding writes
+type lock interface {
+	// lockColumn returns the name of the column holding the lock
+	lockColumn(stmt *gorm.Statement) string
+	// readValue returns the lock value the model was last read or created at
+	readValue() interface{}
+	// unread reports whether the model has never been read or created
+	unread() bool
+	// currentValue returns the in-memory lock value
+	currentValue() interface{}
+	// currentValuePtr returns a pointer to the in-memory lock value, for scanning the lock value from the database
+	currentValuePtr() interface{}
+	// advance moves the in-memory lock value on ready for a write, returning the new value
+	advance(stmt *gorm.Statement) (interface{}, error)
+	// markRead records the in-memory lock value as the one last read from the database
+	markRead()
+	// describe records the lock value the model was read at in a conflict error
+	describe(err *ConflictError)
+}
+
+// databaseAdvancedLock is implemented by locks whose value the database moves on by itself whenever a row is written,
+// so there is no new value to write
+type databaseAdvancedLock interface {
+	lock
+	advancedByDatabase()
+}
+
+// contentLock is implemented by locks whose value is derived from the content of the model, so can only be moved on by
+// writes whose changes are known
+type contentLock interface {
+	lock
+	contentLocked()
+}
+
+// isAdvancedByDatabase reports whether the database moves the lock value on by itself
+func isAdvancedByDatabase(l lock) bool {
+	_, ok := l.(databaseAdvancedLock)
+	return ok
+}
+
+// guardWrite restricts the statement to rows still holding the lock value the model was read at, and (if advance is
+// set) makes it write a new lock value
+func guardWrite(tx *gorm.DB, l lock, advance bool) error {
+	column := l.lockColumn(tx.Statement)
+	guard := &writeGuard{column: column}
+
+	switch {
+	case isUnchecked(tx.Statement):
+		// the write is not guarded, and the lock value can only move on if there is a value to move on from
+		advance = advance && !l.unread()
+		guard.unchecked = true
+	case isForced(tx.Statement):
+		// rather than guarding on the version the model was read at, make sure the new version follows on from the
+		// one currently in the database
+		if err := refreshLockValue(tx, l, column); err != nil {
+			return err
+		}
+		guard.forced, guard.expected = true, l.readValue()
+	default:
+		addGuard(tx.Statement, column, l.readValue())
+		guard.expected = l.readValue()
+	}
+
+	if advance && !isAdvancedByDatabase(l) {
+		value, err := nextLockValue(tx.Statement, l)
+		if err != nil {
+			return err
+		}
+		setLockValue(tx.Statement, column, value)
+		guard.advanced, guard.value = true, value
+	}
+
+	if fl, ok := l.(*fieldLock); ok {
+		guard.recordFieldRead(tx.Statement, fl)
+	}
+	tx.Statement.Settings.Store(guardKey, guard)
+	return nil
+}
+
+// nextLockValue returns the lock value the statement should write, which is the model's own if the statement was
+// scoped with ExplicitVersion, or with AllowVersionAhead and the model's version is ahead of its read version
+func nextLockValue(stmt *gorm.Statement, l lock) (interface{}, error) {
+	if v, ok := l.(*Versioned); ok && allowsVersionAhead(stmt) && !isExplicit(stmt) {
+		switch {
+		case v.Version < v.readVersion:
+			return nil, ErrVersionBehind
+		case v.Version > v.readVersion:
+			return v.Version, nil
+		}
+	}
+	if !isExplicit(stmt) {
+		return l.advance(stmt)
+	}
+
+	value := l.currentValue()
+	if value == l.readValue() {
+		return nil, ErrVersionUnchanged
+	}
+	return value, nil
+}
+
+// refreshLockValue reads the lock value currently stored in the database for the model being processed by the statement
+func refreshLockValue(tx *gorm.DB, l lock, column string) error {
+	conditions, ok := primaryKeyConditions(tx.Statement)
+	if !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+
+	err := tx.Session(&gorm.Session{NewDB: true}).Table(tx.Statement.Table).Select(column).
+		Where(clause.And(conditions...)).Row().Scan(l.currentValuePtr())
+	if err == sql.ErrNoRows {
+		return gorm.ErrRecordNotFound
+	} else if err != nil {
+		return err
+	}
+
+	l.markRead()
+
+	return nil
+}
+
+func beforeUpdate(tx *gorm.DB, l lock) error {
+	if err := applyExpectedVersion(tx.Statement, l); err != nil {
+		return err
+	}
+	if l.unread() && requiresRead(tx.Statement, l) {
+		return ErrUnknownReadVersion
+	}
+	if report, ok := dryRunReport(tx.Statement); ok {
+		report.check(tx, l, OperationUpdate)
+		return nil
+	}
+
+	if err := applyVersionIncrement(tx.Statement, l); err != nil {
+		return err
+	}
+	if err := guardWrite(tx, l, true); err != nil {
+		return err
+	}
+	keepLockValue(tx.Statement)
+
+	return nil
+}
+
+// requiresRead reports whether the statement can only update the model if it knows the lock value the model was read
+// at, rather than guarding on a lock value that would never match. Saving a model that was never read may go on to
+// create it, and resolving conflicts reloads the lock value anyway. Field locks take the field's value to be the
+// value they were read at, so are never really unread
+func requiresRead(stmt *gorm.Statement, l lock) bool {
+	if _, ok := l.(*fieldLock); ok {
+		return false
+	}
+
+	return !isSaveUpdate(stmt) && !isUnchecked(stmt) && !isForced(stmt) && resolution(stmt) == Reject
+}
+
+func afterUpdate(tx *gorm.DB, l lock) error {
+	if tx.DryRun {
+		// nothing was written, whether or not the session is one created by DryRun
+		return nil
+	}
+	if l.unread() && isSaveUpdate(tx.Statement) {
+		// a model that was never read being saved might not exist yet, in which case GORM's Save needs to see no rows
+		// affected (rather than an error) so that it can go on to create it. If its row does exist, the update only
+		// missed it because there was no read version to guard on, and creating it would fail on its primary key
+		if tx.Error == nil && noRowsAffected(tx) {
+			exists, err := rowExists(tx)
+			if err != nil {
+				return err
+			} else if exists {
+				return ErrUnknownReadVersion
+			}
+		}
+		return nil
+	}
+
+	err := ensureRowsAffected(tx, l, OperationUpdate)
+	if IsConflict(err) && resolution(tx.Statement) != Reject {
+		err = overwriteConflict(tx, l, err)
+	} else if err == nil && isChained(tx.Statement) && !l.unread() && !noRowsAffected(tx) {
+		l.markRead()
+	}
+	recordAdvance(tx, l, err)
+
+	return err
+}
+
+func beforeDelete(tx *gorm.DB, l lock) error {
+	if err := applyExpectedVersion(tx.Statement, l); err != nil {
+		return err
+	}
+	op := deleteOperation(tx.Statement)
+	if report, ok := dryRunReport(tx.Statement); ok {
+		report.check(tx, l, op)
+		return nil
+	}
+
+	previous := l.currentValue()
+	if op == OperationSoftDelete {
+		if err := applyDeleteVersion(tx.Statement, l); err != nil {
+			return err
+		}
+	}
+	if err := guardWrite(tx, l, op == OperationSoftDelete); err != nil {
+		restoreLockValue(l, previous)
+		return err
+	}
+	if op == OperationSoftDelete && identifiesRow(tx.Statement) {
+		// write the new lock value in the soft delete itself, rather than following it with another update. Deletes of
+		// several rows, or of rows not fully identified by their primary key, would write it to every row they delete
+		keepLockValue(tx.Statement)
+	}
+
+	return validateDelete(tx, l, previous)
+}
+
+func afterDelete(tx *gorm.DB, l lock) error {
+	if tx.DryRun {
+		return nil
+	}
+
+	op := deleteOperation(tx.Statement)
+	if err := ensureRowsAffected(tx, l, op); err != nil {
+		recordAdvance(tx, l, err)
+		_, missing := err.(*MissingRowError)
+		if plugin, ok := installedPlugin(tx); ok && plugin.opts.IdempotentDelete && missing {
+			// the row is already gone, which is all the delete was for
+			return nil
+		}
+		return err
+	}
+
+	if tx.Error != nil {
+		recordAdvance(tx, l, nil)
+		return nil
+	}
+
+	var err error
+	if op == OperationSoftDelete && !isAdvancedByDatabase(l) && !lockValueAssigned(tx.Statement) {
+		err = persistSoftDeleteLockValue(tx, l.lockColumn(tx.Statement), l.readValue(), l.currentValue())
+	}
+	recordAdvance(tx, l, err)
+
+	return err
+}
+
+// recordAdvance records whether the write made by the statement moved the version of a Versioned model on, see
+// Versioned.VersionAdvanced
+func recordAdvance(tx *gorm.DB, l lock, err error) {
+	if v, ok := l.(*Versioned); ok {
+		v.advanced = err == nil && tx.Error == nil && lockValueAdvanced(tx.Statement)
+	}
+}
+
+func afterRead(tx *gorm.DB, l lock) error {
+	if tx.Error != nil {
+		return nil
+	}
+
+	l.markRead()
+
+	return nil
+}
+
+// ensureRowsAffected detects concurrent modification by checking whether the guarded write modified any rows
+func ensureRowsAffected(tx *gorm.DB, l lock, op Operation) error {
+	if tx.DryRun {
+		// the write was only built, not executed, so there are no affected rows to check
+		return nil
+	}
+	if isUnchecked(tx.Statement) {
+		// unguarded writes cannot conflict, and a write that matched nothing was never counted as guarded
+		return nil
+	}
+
+	if noRowsAffected(tx) {
+		if exists, err := rowExists(tx); err != nil {
+			return err
+		} else if !exists {
+			return &MissingRowError{Operation: op, Table: tx.Statement.Table, PrimaryKey: primaryKeyOf(tx.Statement)}
+		}
+
+		err := newConflictError(tx.Statement, op)
+		l.describe(err)
+		metricsFor(tx.Statement).recordConflict(tx.Statement.Table)
+		recordTransactionConflict(tx.Statement, err)
+		reportConflict(tx.Statement, err)
+		checkContention(tx, l, err)
+		vetoed := vetoConflict(tx.Statement, tableConflictError(err))
+		if IsConflict(vetoed) && (op != OperationUpdate || resolution(tx.Statement) == Reject) {
+			// conflicting updates that are to be resolved are not yet unhandled
+			panicOnConflict(tx, err)
+		}
+		return vetoed
+	}
+
+	metricsFor(tx.Statement).recordWrite(tx.Statement.Table, op)
+	logTransition(tx, l, op)
+
+	return auditTransition(tx, tx.Statement, l, op)
+}
+
+// rowExists reports whether the row of the model currently being processed by the statement can still be found, with
+// the same scoping (e.g. of soft deleted rows) as the statement. Models without a primary key are assumed to exist
+func rowExists(tx *gorm.DB) (bool, error) {
+	stmt := tx.Statement
+	conditions, ok := primaryKeyConditions(stmt)
+	if !ok {
+		return true, nil
+	}
+
+	query := tx.Session(&gorm.Session{NewDB: true})
+	if stmt.Unscoped {
+		query = query.Unscoped()
+	}
+
+	var count int64
+	err := query.Model(reflect.New(stmt.Schema.ModelType).Interface()).Table(stmt.Table).Where(clause.And(conditions...)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// logTransition logs the lock value moving on as the result of a write, if the Plugin is configured to
+func logTransition(tx *gorm.DB, l lock, op Operation) {
+	if plugin, ok := installedPlugin(tx); !ok || !plugin.opts.LogVersionTransitions {
+		return
+	}
+
+	stmt := tx.Statement
+	switch {
+	case op == OperationHardDelete:
+		tx.Logger.Info(stmt.Context, "optimistic: %s %s %v at version %v",
+			op.pastTense(), stmt.Table, primaryKeyOf(stmt), l.readValue())
+	case isAdvancedByDatabase(l):
+		tx.Logger.Info(stmt.Context, "optimistic: %s %s %v from version %v",
+			op.pastTense(), stmt.Table, primaryKeyOf(stmt), l.readValue())
+	default:
+		tx.Logger.Info(stmt.Context, "optimistic: %s %s %v from version %v to %v",
+			op.pastTense(), stmt.Table, primaryKeyOf(stmt), l.readValue(), l.currentValue())
+	}
+}
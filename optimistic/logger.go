@@ -0,0 +1,29 @@
+package optimistic
+
+import (
+	"gorm.io/gorm"
+)
+
+// Logger receives the package's own diagnostics, such as conflicts being detected and transactions being retried,
+// independently of GORM's logger
+type Logger interface {
+	// Debugf logs routine events, such as a transaction being retried
+	Debugf(format string, args ...interface{})
+	// Warnf logs events that usually need attention, such as a conflict being detected
+	Warnf(format string, args ...interface{})
+}
+
+// nopLogger is the Logger used unless the Plugin is given one, discarding everything
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func (nopLogger) Warnf(format string, args ...interface{}) {}
+
+// loggerFor returns the Logger the package's diagnostics about writes to the database are logged through
+func loggerFor(db *gorm.DB) Logger {
+	if plugin, ok := installedPlugin(db); ok {
+		return plugin.opts.Logger
+	}
+	return nopLogger{}
+}
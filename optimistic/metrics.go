@@ -0,0 +1,93 @@
+package optimistic
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics counts the guarded writes and conflicts seen by this package, so that they can be exported to any metrics
+// backend by periodically taking a Snapshot
+var Metrics = &MetricsRegistry{}
+
+// TableMetrics holds the counters for a single table
+type TableMetrics struct {
+	// UpdatesTotal is the number of guarded updates that succeeded
+	UpdatesTotal uint64
+	// DeletesTotal is the number of guarded (soft or hard) deletes that succeeded
+	DeletesTotal uint64
+	// ConflictsTotal is the number of guarded updates and deletes that failed due to concurrent modification
+	ConflictsTotal uint64
+}
+
+// MetricsSnapshot is a point in time copy of the counters in a MetricsRegistry
+type MetricsSnapshot struct {
+	TableMetrics
+	// Tables holds the counters for each table, keyed by table name
+	Tables map[string]TableMetrics
+}
+
+// MetricsRegistry holds counters of guarded writes and conflicts, it is safe for concurrent use
+type MetricsRegistry struct {
+	mu     sync.Mutex
+	tables map[string]*TableMetrics
+}
+
+// Snapshot returns a copy of the current counters
+func (r *MetricsRegistry) Snapshot() MetricsSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Tables: make(map[string]TableMetrics, len(r.tables)),
+	}
+	for table, counters := range r.tables {
+		copied := TableMetrics{
+			UpdatesTotal:   atomic.LoadUint64(&counters.UpdatesTotal),
+			DeletesTotal:   atomic.LoadUint64(&counters.DeletesTotal),
+			ConflictsTotal: atomic.LoadUint64(&counters.ConflictsTotal),
+		}
+		snapshot.Tables[table] = copied
+		snapshot.UpdatesTotal += copied.UpdatesTotal
+		snapshot.DeletesTotal += copied.DeletesTotal
+		snapshot.ConflictsTotal += copied.ConflictsTotal
+	}
+
+	return snapshot
+}
+
+// Reset sets every counter back to zero
+func (r *MetricsRegistry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tables = nil
+}
+
+func (r *MetricsRegistry) table(name string) *TableMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counters, ok := r.tables[name]
+	if !ok {
+		if r.tables == nil {
+			r.tables = map[string]*TableMetrics{}
+		}
+		counters = &TableMetrics{}
+		r.tables[name] = counters
+	}
+
+	return counters
+}
+
+func (r *MetricsRegistry) recordWrite(table string, op Operation) {
+	counters := r.table(table)
+	if op == OperationUpdate {
+		atomic.AddUint64(&counters.UpdatesTotal, 1)
+	} else {
+		atomic.AddUint64(&counters.DeletesTotal, 1)
+	}
+}
+
+func (r *MetricsRegistry) recordConflict(table string) {
+	atomic.AddUint64(&r.table(table).ConflictsTotal, 1)
+}
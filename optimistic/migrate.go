@@ -0,0 +1,64 @@
+package optimistic
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EnsureVersionColumn adopts optimistic locking on an existing table, adding the version column of the model (which
+// must embed Versioned, or have a field tagged as its version) to the model's table if the table does not have it yet,
+// then setting the version of every row without one to the version models are created at (1, unless the Plugin is
+// configured with another InitialVersion). Unlike AutoMigrate, this leaves the rows ready to be read and written as
+// Versioned models, even if the column was previously added without a default. It returns ErrNotLocked for models
+// without a version number
+func EnsureVersionColumn(db *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	field, ok := versionField(stmt)
+	if !ok {
+		return ErrNotLocked
+	}
+
+	migrator := db.Migrator()
+	if !migrator.HasColumn(model, field.DBName) {
+		if err := migrator.AddColumn(model, field.Name); err != nil {
+			return err
+		}
+	}
+
+	return db.Session(&gorm.Session{NewDB: true}).Table(stmt.Table).
+		Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: nil}).
+		UpdateColumn(field.DBName, initialVersion(db)).Error
+}
+
+// ValidateVersionColumns checks that the tables of the given locked models have their lock columns, e.g. at startup to
+// catch a model that was given a lock without its table being migrated, which would otherwise fail every write with a
+// database error. It returns a *MissingVersionColumnError listing every column that is missing, or ErrNotLocked if
+// any of the models is not locked
+func ValidateVersionColumns(db *gorm.DB, models ...interface{}) error {
+	migrator := db.Migrator()
+	missing := &MissingVersionColumnError{}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		column, ok := lockColumnOf(stmt, model)
+		if !ok {
+			return ErrNotLocked
+		}
+
+		if !migrator.HasColumn(model, column) {
+			missing.Columns = append(missing.Columns, stmt.Table+"."+column)
+		}
+	}
+
+	if len(missing.Columns) > 0 {
+		return missing
+	}
+	return nil
+}
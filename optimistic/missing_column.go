@@ -0,0 +1,60 @@
+package optimistic
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// missingColumnMessages are the fragments of the errors databases fail statements referring to unknown columns with,
+// in lower case: sqlite (for inserts, then other statements), MySQL, PostgreSQL and SQL Server respectively
+var missingColumnMessages = []string{
+	"has no column named", "no such column", "unknown column", "does not exist", "invalid column name",
+}
+
+// lockColumnOf returns the name of the lock column of the given model, reporting false if the model is not locked
+func lockColumnOf(stmt *gorm.Statement, model interface{}) (string, bool) {
+	if l, ok := model.(lock); ok {
+		return l.lockColumn(stmt), true
+	}
+	if field, ok := lockField(stmt.Schema); ok {
+		return field.DBName, true
+	}
+	return "", false
+}
+
+// checkLockColumn replaces the error a write to a locked model failed with by a *MissingVersionColumnError if the
+// database failed it because the model's table does not have its lock column
+func checkLockColumn(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error == nil || stmt.Schema == nil {
+		return
+	}
+	if _, ok := db.Error.(*MissingVersionColumnError); ok {
+		return
+	}
+
+	column, ok := lockColumnOf(stmt, reflect.New(stmt.Schema.ModelType).Interface())
+	if !ok || !isMissingColumnError(db.Error, column) {
+		return
+	}
+
+	// replace rather than add to the error, GORM would otherwise join the two into an error wrapping neither
+	db.Error = &MissingVersionColumnError{Columns: []string{stmt.Table + "." + column}, Err: db.Error}
+}
+
+// isMissingColumnError reports whether err is a database's complaint that the named column does not exist
+func isMissingColumnError(err error, column string) bool {
+	message := strings.ToLower(err.Error())
+	if !strings.Contains(message, strings.ToLower(column)) {
+		return false
+	}
+
+	for _, fragment := range missingColumnMessages {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
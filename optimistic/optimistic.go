@@ -1,96 +1,135 @@
 package optimistic
 
 import (
-	"errors"
+	"math"
+	"reflect"
 
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
-// ErrConcurrentModification is returned when concurrent modification is detected during an Update or Delete operation
-// on a Versioned model
-var ErrConcurrentModification = errors.New("concurrent modification detected")
+// defaultVersionColumn is the name of the version column, unless renamed using GORM's embeddedPrefix tag
+const defaultVersionColumn = "version"
 
-// Versioned can be embedded in a GORM model to add optimistic locking
+var versionedType = reflect.TypeOf(Versioned{})
+
+// Versioned can be embedded in a GORM model to add optimistic locking. The version is stored in a column named
+// "version", which can be given a prefix using GORM's embeddedPrefix tag, e.g. `gorm:"embeddedPrefix:row_"` to store it
+// in a column named "row_version". The version a model was read at is held by the model itself rather than by the
+// *gorm.DB it was read through, so a model can be written through any handle on the database it was read from (e.g.
+// one of several shards) and is guarded independently of models read from other databases
 type Versioned struct {
-	Version     uint64 `gorm:"not null;default:1;"`
+	Version     uint64 `gorm:"not null;default:1;" json:"version"`
 	readVersion uint64 `gorm:"-"`
+	read        bool   `gorm:"-"`
+	advanced    bool   `gorm:"-"`
+}
+
+// ReadVersion returns the version this model was last read (or created) at, which is what updates and deletes check the
+// database against. It is zero for a model that has never been read or created
+func (v *Versioned) ReadVersion() uint64 {
+	return v.readVersion
+}
+
+// SetReadVersion sets the version updates and deletes of this model check the database against. Unlike Version the
+// read version is not serialized, so use this to restore the version a client was shown once the model has been
+// deserialized from its request, making a write fail if the model has been modified since the client read it
+func (v *Versioned) SetReadVersion(version uint64) {
+	v.readVersion = version
+	v.read = true
+	v.advanced = false
+}
+
+// VersionAdvanced reports whether the last update or delete of this model succeeded and moved its stored version on
+// from the version it was read at, e.g. to assert on in tests and tooling. It is false once the model is read again,
+// and for hard deletes, which leave no version behind
+func (v *Versioned) VersionAdvanced() bool {
+	return v.advanced
 }
 
 // BeforeUpdate ensures that updates to a Versioned model only apply if there has not been a concurrent modification,
 // detected through an optimistic lock version, and asserts that the new object will have a new version
 func (v *Versioned) BeforeUpdate(tx *gorm.DB) error {
-	return v.assertLockValidity(tx, true)
+	return methodHook(tx, v, beforeUpdate)
 }
 
 // AfterUpdate detects concurrent modification issues
 func (v *Versioned) AfterUpdate(tx *gorm.DB) error {
-	return v.ensureRowsAffected(tx)
+	return methodHook(tx, v, afterUpdate)
 }
 
 // BeforeDelete ensures that deleting a Versioned model only applies if there has not been a concurrent modification,
 // detected through an optimistic lock version, and asserts that the deleted object will have a new version
 func (v *Versioned) BeforeDelete(tx *gorm.DB) error {
-	isSoftDelete := !tx.Statement.Unscoped
-	return v.assertLockValidity(tx, isSoftDelete)
+	return methodHook(tx, v, beforeDelete)
 }
 
 // AfterDelete detects concurrent modification issues
 func (v *Versioned) AfterDelete(tx *gorm.DB) error {
-	if err := v.ensureRowsAffected(tx); err != nil {
-		return err
-	}
-
-	if tx.Error != nil {
-		return nil
-	}
-
-	// workaround for GORM issue https://github.com/go-gorm/gorm/pull/3893#issuecomment-877706731
-	isSoftDelete := !tx.Statement.Unscoped
-	if isSoftDelete {
-		tx.Unscoped().Model(tx.Statement.Dest).Where("version = ?", v.readVersion).UpdateColumn("version", v.Version)
-	}
+	return methodHook(tx, v, afterDelete)
+}
 
-	return nil
+// BeforeCreate ensures that upserts of a Versioned model increment the version of the existing row, rather than
+// overwriting it with the version being created
+func (v *Versioned) BeforeCreate(tx *gorm.DB) error {
+	return methodHook(tx, v, beforeCreate)
 }
 
-// AfterCreate sets the internal read version to reflect the created version
+// AfterCreate sets the internal read version to reflect the created version, GORM calls it for each element when
+// creating a slice
 func (v *Versioned) AfterCreate(tx *gorm.DB) error {
-	if tx.Error != nil {
-		return nil
-	}
+	return methodHook(tx, v, afterCreate)
+}
 
-	v.readVersion = v.Version
+// AfterFind sets the internal read version based on the retrieved version, GORM calls it for each element when loading
+// a slice so every element tracks the version of its own row
+func (v *Versioned) AfterFind(tx *gorm.DB) error {
+	return methodHook(tx, v, afterRead)
+}
 
-	return nil
+func (v *Versioned) versioned() *Versioned {
+	return v
 }
 
-// AfterFind sets the internal read version based on the retrieved version
-func (v *Versioned) AfterFind(tx *gorm.DB) error {
-	if tx.Error != nil {
-		return nil
-	}
+func (v *Versioned) lockColumn(stmt *gorm.Statement) string {
+	return versionColumn(stmt)
+}
 
-	v.readVersion = v.Version
+func (v *Versioned) readValue() interface{} {
+	return v.readVersion
+}
 
-	return nil
+func (v *Versioned) currentValue() interface{} {
+	return v.Version
 }
 
-func (v *Versioned) assertLockValidity(tx *gorm.DB, updateVersion bool) error {
-	tx.Statement.Where("version = ?", v.readVersion)
+func (v *Versioned) currentValuePtr() interface{} {
+	return &v.Version
+}
 
-	if updateVersion {
-		v.Version = v.readVersion + 1
-		tx.Statement.AddClause(clause.Set{{Column: clause.Column{Name: "version"}, Value: v.Version}})
+func (v *Versioned) unread() bool {
+	return !v.read
+}
+
+func (v *Versioned) advance(stmt *gorm.Statement) (interface{}, error) {
+	if v.readVersion == math.MaxUint64 {
+		return nil, ErrVersionOverflow
 	}
 
-	return nil
+	v.Version = v.readVersion + 1
+	return v.Version, nil
 }
 
-func (v *Versioned) ensureRowsAffected(tx *gorm.DB) error {
-	if tx.Statement.DB.RowsAffected < 1 {
-		return ErrConcurrentModification
-	}
+func (v *Versioned) markRead() {
+	v.readVersion = v.Version
+	v.read = true
+	v.advanced = false
+}
+
+func (v *Versioned) describe(err *ConflictError) {
+	err.ExpectedVersion = v.readVersion
+}
 
-	return nil
+// versionColumn returns the name of the column holding the version of the Versioned model the statement operates on
+func versionColumn(stmt *gorm.Statement) string {
+	return embeddedColumn(stmt, versionedType, "Version", defaultVersionColumn)
 }
@@ -0,0 +1,271 @@
+package optimistic
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// pluginName is the name the Plugin is registered with GORM under
+const pluginName = "optimistic"
+
+// PluginOptions configures the Plugin
+type PluginOptions struct {
+	// Retry holds the defaults RunWithRetry uses for databases the plugin is installed on, other than the number of
+	// attempts which RunWithRetry is always given
+	Retry RetryOptions
+	// Metrics is the registry guarded writes and conflicts are counted in, defaulting to the package level Metrics
+	Metrics *MetricsRegistry
+	// LogVersionTransitions logs every successful guarded write, along with the versions it moved the model between,
+	// as an info message through GORM's logger
+	LogVersionTransitions bool
+	// AuditVersions records every successful guarded write in an audit table alongside the written model's table, in
+	// the same transaction as the write. Create the audit tables with MigrateVersionAudit
+	AuditVersions bool
+	// AuditTableName names the audit table of a model's table, defaulting to the table's name followed by "_versions"
+	AuditTableName func(table string) string
+	// InitialVersion is the version models are created at, defaulting to 1, e.g. to start versions at 0. Models are
+	// created with the version written explicitly, so the default AutoMigrate gives the version column (which is taken
+	// from the struct tag of the version field) only applies to rows inserted by other means
+	InitialVersion *uint64
+	// IdempotentDelete makes deletes of models whose rows no longer exist (or have already been soft deleted) succeed,
+	// rather than fail with a MissingRowError. Deletes of models that have been modified since they were read still
+	// conflict
+	IdempotentDelete bool
+	// Logger receives warnings of conflicts and notices of retries, independently of GORM's logger (which may be
+	// disabled, or too verbose to leave enabled), defaulting to discarding them
+	Logger Logger
+	// HighContentionThreshold, if set, makes a conflicting update or delete read the row's current version (with an
+	// extra SELECT) and call OnHighContention if the row has been written more than this many times since the model
+	// was read, as early warning of rows that writers are contending over. The conflict is handled as usual either way
+	HighContentionThreshold uint64
+	// OnHighContention is called with the context of the conflicting statement when HighContentionThreshold is crossed
+	OnHighContention HighContentionCallback
+	// Tracer, if set, starts a span around every guarded update and delete, as a child of any span in the statement's
+	// context, recording the table, the version the write was guarded on and whether it conflicted
+	Tracer Tracer
+	// PanicOnConflict makes writes that conflict panic, with a message describing the conflict, rather than fail with
+	// the conflict, to make conflicts that go unhandled impossible to miss during development and testing. Conflicts
+	// vetoed by their model, or resolved by Resolve, do not panic
+	PanicOnConflict bool
+}
+
+// Plugin is a GORM plugin that guards writes to Versioned models using callbacks registered with GORM, rather than the
+// method hooks Versioned provides, which means models can define their own hooks without having to call through to
+// those of Versioned. It also guards models that, rather than embedding Versioned, tag an integer field with
+// `gorm:"optimisticlock"`. Other kinds of lock continue to use their method hooks. Writes that fail because the
+// model's table does not have its lock column fail with a *MissingVersionColumnError. Writes are guarded at fixed
+// positions among GORM's callbacks, rather than in whatever order method hooks happen to be resolved: after the model's
+// own BeforeSave, BeforeUpdate and BeforeDelete hooks (so a hook refusing a write leaves its version as it was), but
+// before associations are saved and the SQL is built; and conflicts are detected before the model's AfterUpdate and
+// AfterDelete hooks are called. Install it with db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))
+type Plugin struct {
+	opts PluginOptions
+	// createSchemas holds the schemas models are created with when InitialVersion is set, by the schema GORM parsed
+	createSchemas sync.Map
+}
+
+var _ gorm.Plugin = (*Plugin)(nil)
+
+// NewPlugin creates a Plugin configured with the given options
+func NewPlugin(opts PluginOptions) *Plugin {
+	if opts.Metrics == nil {
+		opts.Metrics = Metrics
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+
+	return &Plugin{
+		opts: opts,
+	}
+}
+
+// Name returns the name of the plugin
+func (p *Plugin) Name() string {
+	return pluginName
+}
+
+// Initialize registers the plugin's callbacks with GORM
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+
+	if err := callback.Create().Before("gorm:before_create").
+		Register("optimistic:check_addressable", checkAddressable); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:before_update").
+		Register("optimistic:check_addressable", checkAddressable); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:before_delete").
+		Register("optimistic:check_addressable", checkAddressable); err != nil {
+		return err
+	}
+	if err := callback.Create().After("gorm:before_create").Before("gorm:save_before_associations").
+		Register("optimistic:before_create", eachLock(beforeCreate)); err != nil {
+		return err
+	}
+	if err := callback.Create().After("optimistic:before_create").Before("gorm:create").
+		Register("optimistic:initial_version", p.applyInitialVersion); err != nil {
+		return err
+	}
+	if err := callback.Create().After("gorm:create").
+		Register("optimistic:after_create", eachLock(afterCreate)); err != nil {
+		return err
+	}
+	if err := callback.Query().After("gorm:query").
+		Register("optimistic:after_query", eachLock(afterRead)); err != nil {
+		return err
+	}
+	// guard updates once the model's own hooks have validated them, but before any associations are saved, so that a
+	// write the guard refuses (say, of a model that was never read) saves none of them
+	if err := callback.Update().After("gorm:before_update").Before("gorm:save_before_associations").
+		Register("optimistic:before_update", eachLock(beforeUpdate)); err != nil {
+		return err
+	}
+	// check for conflicts before associations are saved, so that a conflicting update does not go on to write the
+	// associations of a model it did not write
+	if err := callback.Update().After("gorm:update").Before("gorm:save_after_associations").
+		Register("optimistic:after_update", eachLock(afterUpdate)); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:before_delete").Before("gorm:delete").
+		Register("optimistic:before_delete", eachLock(beforeDelete)); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Before("gorm:after_delete").
+		Register("optimistic:after_delete", eachLock(afterDelete)); err != nil {
+		return err
+	}
+	// recognise writes failing because the lock column is missing, before later callbacks see the database's error
+	if err := callback.Create().After("gorm:create").Before("optimistic:after_create").
+		Register("optimistic:check_lock_column", checkLockColumn); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Before("optimistic:after_update").
+		Register("optimistic:check_lock_column", checkLockColumn); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Before("optimistic:after_delete").
+		Register("optimistic:check_lock_column", checkLockColumn); err != nil {
+		return err
+	}
+	if err := callback.Update().After("optimistic:before_update").Before("gorm:update").
+		Register("optimistic:start_span", p.startSpan(updateOperation)); err != nil {
+		return err
+	}
+	if err := callback.Update().After("optimistic:after_update").
+		Register("optimistic:end_span", endSpan); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("optimistic:before_delete").Before("gorm:delete").
+		Register("optimistic:start_span", p.startSpan(deleteOperation)); err != nil {
+		return err
+	}
+	return callback.Delete().After("optimistic:after_delete").
+		Register("optimistic:end_span", endSpan)
+}
+
+// installedPlugin returns the Plugin installed on the database, if any
+func installedPlugin(tx *gorm.DB) (*Plugin, bool) {
+	if tx.Config == nil {
+		return nil, false
+	}
+	plugin, ok := tx.Config.Plugins[pluginName].(*Plugin)
+	return plugin, ok
+}
+
+// metricsFor returns the registry writes made by the statement are counted in
+func metricsFor(stmt *gorm.Statement) *MetricsRegistry {
+	if plugin, ok := installedPlugin(stmt.DB); ok {
+		return plugin.opts.Metrics
+	}
+	return Metrics
+}
+
+// lockType is the type of the interface implemented by the embeddable lock types
+var lockType = reflect.TypeOf((*lock)(nil)).Elem()
+
+// checkAddressable fails writes of locked models that were passed by value rather than by pointer, since the model's
+// new lock value could not be written back to it (and GORM itself panics calling the method hooks of such models)
+func checkAddressable(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.SkipHooks {
+		return
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct, reflect.Array:
+		if stmt.ReflectValue.CanAddr() {
+			return
+		}
+	default:
+		return
+	}
+
+	if _, hasField := lockField(stmt.Schema); hasField || reflect.PtrTo(stmt.Schema.ModelType).Implements(lockType) {
+		db.AddError(ErrUnaddressableModel)
+	}
+}
+
+// versionedModel is implemented by models embedding Versioned
+type versionedModel interface {
+	versioned() *Versioned
+}
+
+// eachLock creates a callback running hook for each model the statement operates on that embeds Versioned or has a
+// field tagged as its version, in the same way GORM calls method hooks
+func eachLock(hook func(tx *gorm.DB, l lock) error) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || db.Statement.Schema == nil || db.Statement.SkipHooks {
+			return
+		}
+
+		field, hasField := lockField(db.Statement.Schema)
+		tx := db.Session(&gorm.Session{NewDB: true})
+		// db is the *gorm.DB that executed the statement, so holds its count of affected rows, whatever DB the
+		// statement refers to
+		tx.RowsAffected = db.RowsAffected
+		call := func(value reflect.Value) {
+			var model interface{}
+			if value.CanAddr() {
+				model = value.Addr().Interface()
+			} else {
+				model = value.Interface()
+			}
+
+			if m, ok := model.(versionedModel); ok {
+				db.AddError(hook(tx, m.versioned()))
+			} else if hasField && value.CanAddr() {
+				l := newFieldLock(field, value, db.Statement)
+				err := hook(tx, l)
+				if err != nil {
+					l.reset()
+				}
+				db.AddError(err)
+			}
+		}
+
+		switch rv := db.Statement.ReflectValue; rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			db.Statement.CurDestIndex = 0
+			for i := 0; i < rv.Len(); i++ {
+				call(reflect.Indirect(rv.Index(i)))
+				db.Statement.CurDestIndex++
+			}
+		case reflect.Struct:
+			call(rv)
+		}
+	}
+}
+
+// methodHook runs hook when GORM calls one of the method hooks of a Versioned model, unless the Plugin is installed
+// in which case its callbacks run the hook instead
+func methodHook(tx *gorm.DB, v *Versioned, hook func(tx *gorm.DB, l lock) error) error {
+	if _, ok := installedPlugin(tx); ok {
+		return nil
+	}
+	return hook(tx, v)
+}
@@ -0,0 +1,98 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reload re-reads a model from the database by its primary key, discarding any changes made to it in memory, so that
+// its writes are checked against the version it is reloaded at - e.g. after a write fails due to concurrent
+// modification, before applying the change again. It returns gorm.ErrRecordNotFound if the row no longer exists
+func Reload(tx *gorm.DB, model interface{}) error {
+	return takeByPrimaryKey(tx, model, model)
+}
+
+// UpdateAndRefresh writes the non-zero fields of the model as GORM's Updates does, provided it has not been modified
+// since it was read, then re-reads it as Reload does so that it holds any values set by the database (e.g. by
+// triggers) along with the version it was written at, which later writes are checked against. The write and the
+// re-read are made in a transaction, and the model is not re-read if the write fails
+func UpdateAndRefresh(tx *gorm.DB, model interface{}) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Updates(model).Error; err != nil {
+			return err
+		}
+
+		return Reload(tx.Session(&gorm.Session{NewDB: true}), model)
+	})
+}
+
+// LoadForUpdate reads the first row matching the conditions (given as they would be to GORM's First) into the model,
+// ready for a guarded write, for read-then-write flows whose reads would not otherwise produce a model to write, e.g.
+// those made with Pluck. Every column is read whatever the *gorm.DB selects, and the model records the version it was
+// read at even if the *gorm.DB skips hooks. It returns ErrNotLocked if the model is not locked, and
+// gorm.ErrRecordNotFound if no row matches
+func LoadForUpdate(tx *gorm.DB, model interface{}, conds ...interface{}) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	if _, ok := lockColumnOf(stmt, model); !ok {
+		return ErrNotLocked
+	}
+
+	if err := tx.Select("*").First(model, conds...).Error; err != nil {
+		return err
+	}
+
+	if l, ok := model.(lock); ok {
+		l.markRead()
+	}
+	return nil
+}
+
+// LoadBatch reads the models with the given primary keys, ready for guarded writes, for batch flows that read many
+// rows, process them elsewhere, then write them back (e.g. with BulkUpdate or UpdateEach). As with LoadForUpdate every
+// column is read, and each model records the version it was read at even if the *gorm.DB skips hooks. Keys without a
+// row are left out, so fewer models than keys may be returned. It returns ErrNotLocked if T is not locked
+func LoadBatch[T any](tx *gorm.DB, ids []interface{}) ([]*T, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	if _, ok := lockColumnOf(stmt, new(T)); !ok {
+		return nil, ErrNotLocked
+	}
+
+	models := make([]*T, 0, len(ids))
+	if len(ids) == 0 {
+		return models, nil
+	}
+	if err := tx.Select("*").Find(&models, ids).Error; err != nil {
+		return nil, err
+	}
+
+	for _, model := range models {
+		if l, ok := interface{}(model).(lock); ok {
+			l.markRead()
+		}
+	}
+	return models, nil
+}
+
+// takeByPrimaryKey reads the row with the primary key of the model into dest, which may be the model itself
+func takeByPrimaryKey(tx *gorm.DB, model interface{}, dest interface{}) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+
+	conditions, ok := primaryKeyConditions(stmt)
+	if !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+
+	return tx.Where(clause.And(conditions...)).Take(dest).Error
+}
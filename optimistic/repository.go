@@ -0,0 +1,71 @@
+package optimistic
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repository provides typed access to a model whose writes are guarded against concurrent modification. Each method
+// runs in its own transaction, and writes fail with an error satisfying IsConflict if the model has been modified
+// since it was read
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a Repository for models of type T stored in the given database
+func NewRepository[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{
+		db: db,
+	}
+}
+
+// GetByID reads the model with the given primary key, returning gorm.ErrRecordNotFound if there is no such model
+func (r *Repository[T]) GetByID(ctx context.Context, id any) (*T, error) {
+	var model T
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.First(&model, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model, nil
+}
+
+// Update writes the non-zero fields of the model, as GORM's Updates does, provided it has not been modified since it
+// was read
+func (r *Repository[T]) Update(ctx context.Context, model *T) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Updates(model).Error
+	})
+}
+
+// Delete deletes the model, provided it has not been modified since it was read
+func (r *Repository[T]) Delete(ctx context.Context, model *T) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Delete(model).Error
+	})
+}
+
+// UpdateReturningLatest writes the non-zero fields of the model as GORM's Updates does, returning the model once
+// written. If the write conflicts, the latest version of the model is read back and returned along with the conflict,
+// e.g. to show the user how it differs from their changes. No model is returned with any other error (such as a
+// MissingRowError, if the model has since been deleted), or if the model could not be read back
+func UpdateReturningLatest[T any](tx *gorm.DB, model *T) (*T, error) {
+	// write through a session of its own, leaving tx free of the write's error for reading back the latest version
+	err := tx.Session(&gorm.Session{}).Updates(model).Error
+	if err == nil {
+		return model, nil
+	} else if !IsConflict(err) {
+		return nil, err
+	}
+
+	// read back the latest version by primary key alone, rather than with any conditions the update was made with
+	latest := new(T)
+	if takeErr := takeByPrimaryKey(tableSession(tx), model, latest); takeErr != nil {
+		return nil, err
+	}
+
+	return latest, err
+}
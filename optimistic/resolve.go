@@ -0,0 +1,122 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	resolutionKey = "optimistic:resolution"
+)
+
+// Resolution determines what happens when a guarded write detects a concurrent modification
+type Resolution int
+
+const (
+	// Reject fails the write with a ConflictError, this is the default
+	Reject Resolution = iota
+	// LastWriteWins reloads the latest version of the row and re-applies the write over the top of the concurrent
+	// modification, still reporting the conflict to any OnConflict callbacks. Models implementing ConflictMerger are
+	// merged with the latest version of the row before the write is re-applied
+	LastWriteWins
+	// Merge resolves a conflicting write to a model implementing ConflictMerger by merging the latest version of the
+	// row into it and re-applying the write, as LastWriteWins does, but rejects conflicting writes to other models
+	Merge
+)
+
+// ConflictMerger can be implemented by models to combine their in-memory changes with those of a concurrent
+// modification when a write conflicts under LastWriteWins or Merge, e.g. to add together concurrent increments of a
+// counter. The write is then re-applied from the merged model, so for updates made from a map only the lock is moved on
+type ConflictMerger interface {
+	// MergeFrom is given the latest version of the model, as a pointer of the same type, and updates the model with the
+	// changes that should be re-applied over the top of it. Returning an error fails the write with that error
+	MergeFrom(latest interface{}) error
+}
+
+// Resolve sets how conflicts detected by updates built from the returned *gorm.DB are resolved, e.g.
+// optimistic.Resolve(db, optimistic.LastWriteWins).Updates(&model). The re-applied write targets the model's row by its
+// primary key, so any other conditions on the original statement are not repeated
+func Resolve(tx *gorm.DB, resolution Resolution) *gorm.DB {
+	return tx.Set(resolutionKey, resolution)
+}
+
+// resolution returns how the statement resolves conflicts
+func resolution(stmt *gorm.Statement) Resolution {
+	if resolution, ok := stmt.Settings.Load(resolutionKey); ok {
+		return resolution.(Resolution)
+	}
+	return Reject
+}
+
+// overwriteConflict resolves a conflicting update by reloading the latest lock value (merging the latest version of the
+// row into the model, if it implements ConflictMerger) and re-applying the update on top of it, returning the original
+// conflict if the row can no longer be written. The update is only re-applied once, so it still fails if it conflicts
+// again
+func overwriteConflict(tx *gorm.DB, l lock, conflict error) error {
+	stmt := tx.Statement
+	merger, canMerge := currentModel(stmt).(ConflictMerger)
+	switch {
+	case canMerge:
+		if err := mergeLatest(tx, l, merger); err == gorm.ErrRecordNotFound || err == gorm.ErrPrimaryKeyRequired {
+			return conflict
+		} else if err != nil {
+			return err
+		}
+	case resolution(stmt) == Merge:
+		return conflict
+	default:
+		if err := refreshLockValue(tx, l, l.lockColumn(stmt)); err != nil {
+			return conflict
+		}
+	}
+
+	rewrite := Resolve(tx.Session(&gorm.Session{NewDB: true}), Reject).Model(stmt.Model).Table(stmt.Table)
+	if len(stmt.Selects) > 0 {
+		rewrite = rewrite.Select(stmt.Selects)
+	}
+	if len(stmt.Omits) > 0 {
+		rewrite = rewrite.Omit(stmt.Omits...)
+	}
+
+	result := rewrite.Updates(stmt.Dest)
+	if result.Error != nil {
+		return result.Error
+	}
+	stmt.DB.RowsAffected = result.RowsAffected
+
+	return nil
+}
+
+// mergeLatest loads the latest version of the model currently being processed by the statement and merges it into the
+// model, which is then treated as having been read at the latest version
+func mergeLatest(tx *gorm.DB, l lock, merger ConflictMerger) error {
+	stmt := tx.Statement
+	conditions, ok := primaryKeyConditions(stmt)
+	if !ok {
+		return gorm.ErrPrimaryKeyRequired
+	}
+
+	latest := reflect.New(currentReflectValue(stmt).Type())
+	err := tx.Session(&gorm.Session{NewDB: true}).Table(stmt.Table).Where(clause.And(conditions...)).
+		Take(latest.Interface()).Error
+	if err != nil {
+		return err
+	}
+
+	if err := merger.MergeFrom(latest.Interface()); err != nil {
+		return err
+	}
+
+	// set the lock value last, in case merging copied over the whole model
+	field := stmt.Schema.LookUpField(l.lockColumn(stmt))
+	if field == nil {
+		return gorm.ErrInvalidField
+	}
+	value, _ := field.ValueOf(latest.Elem())
+	reflect.ValueOf(l.currentValuePtr()).Elem().Set(reflect.ValueOf(value))
+	l.markRead()
+
+	return nil
+}
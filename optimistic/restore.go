@@ -0,0 +1,27 @@
+package optimistic
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotSoftDeletable is returned by Restore when given a model without a gorm.DeletedAt field
+var ErrNotSoftDeletable = errors.New("model does not support soft deletion")
+
+// Restore undoes the soft deletion of a model, which must have been read (e.g. using Unscoped) since it was deleted.
+// Like any other update, it only applies if there has not been a concurrent modification and increments the version
+func Restore(tx *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	for _, field := range stmt.Schema.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			return tx.Unscoped().Model(model).Update(field.DBName, nil).Error
+		}
+	}
+
+	return ErrNotSoftDeletable
+}
@@ -0,0 +1,215 @@
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetriesExhaustedError is returned by RunWithRetry when every attempt failed due to concurrent modification, or with
+// another error that RetryOptions.RetryIf chose to retry
+type RetriesExhaustedError struct {
+	// Attempts is the number of attempts that were made before giving up
+	Attempts int
+	// Err is the error returned by the final attempt
+	Err error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("retries exhausted after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error returned by the final attempt
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// RetryOptions controls how many attempts RunWithRetryOpts makes and how long it waits between them
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of attempts to make, values less than 1 are treated as 1
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubling for each retry after that. Zero disables waiting
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts (before jitter is applied). Zero means no cap
+	MaxDelay time.Duration
+	// Jitter randomly varies each delay by up to this fraction of itself, e.g. 0.2 gives delays within ±20%
+	Jitter float64
+	// Rand is the source of the jitter, defaulting to math/rand's shared source, e.g. to make delays reproducible in
+	// tests with rand.New(rand.NewSource(seed)). A *rand.Rand is not safe for concurrent use, so it should not be
+	// given to retries that may run at the same time
+	Rand *rand.Rand
+	// RetryIf reports whether an attempt failing with an error other than a concurrent modification should be retried,
+	// e.g. to retry when the database reports a lock it could not acquire (such as SQLite's SQLITE_BUSY) with the same
+	// backoff. Concurrent modifications are always retried, and if nil nothing else is
+	RetryIf func(err error) bool
+}
+
+// retries reports whether an attempt failing with the error should be retried
+func (o RetryOptions) retries(err error) bool {
+	return IsConflict(err) || (err != nil && o.RetryIf != nil && o.RetryIf(err))
+}
+
+// Delay returns how long to wait after the given (zero-based) failed attempt before trying again
+func (o RetryOptions) Delay(attempt int) time.Duration {
+	if o.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := o.BaseDelay
+	for i := 0; i < attempt; i++ {
+		if o.MaxDelay > 0 && delay >= o.MaxDelay {
+			break
+		}
+		if delay > delay<<1 {
+			// doubling again would overflow
+			break
+		}
+		delay <<= 1
+	}
+
+	if o.MaxDelay > 0 && delay > o.MaxDelay {
+		delay = o.MaxDelay
+	}
+
+	if o.Jitter > 0 {
+		random := rand.Float64
+		if o.Rand != nil {
+			random = o.Rand.Float64
+		}
+		delay += time.Duration((random()*2 - 1) * o.Jitter * float64(delay))
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	return delay
+}
+
+// RunWithRetry runs fn inside a transaction, making up to maxAttempts attempts for as long as it fails due to
+// concurrent modification. Any other error is returned unchanged, and a RetriesExhaustedError is returned if every
+// attempt results in a concurrent modification. If the Plugin is installed, it waits between attempts as configured by
+// the plugin's retry options
+func RunWithRetry(db *gorm.DB, maxAttempts int, fn func(tx *gorm.DB) error) error {
+	return RunWithRetryOpts(db, defaultRetryOptions(db, maxAttempts), fn)
+}
+
+// RunWithRetryOpts behaves like RunWithRetry, but waits between attempts with an exponential backoff as configured by
+// opts. If the context carried by db is done whilst waiting, the context's error is returned instead
+func RunWithRetryOpts(db *gorm.DB, opts RetryOptions, fn func(tx *gorm.DB) error) error {
+	_, err := runWithRetry(db, opts, fn)
+	return err
+}
+
+// RetryStats describes the attempts RunWithRetryStats made
+type RetryStats struct {
+	// Attempts is the number of attempts that were made, including the final one
+	Attempts int
+	// Conflicts is the number of concurrent modifications detected across every attempt, including those detected by
+	// writes that did not fail the attempt (e.g. when resolved with LastWriteWins). An attempt failing with
+	// ErrConcurrentModification without any of its writes having detected a conflict counts as a single conflict
+	Conflicts int
+}
+
+// RunWithRetryStats behaves like RunWithRetryOpts, but also reports how many attempts were made and how many
+// conflicts they saw, whether or not the final attempt succeeded
+func RunWithRetryStats(db *gorm.DB, opts RetryOptions, fn func(tx *gorm.DB) error) (RetryStats, error) {
+	return runWithRetry(db, opts, fn)
+}
+
+// Retrier runs transactions that are retried on concurrent modification, for call sites that chain methods on the
+// *gorm.DB, e.g. optimistic.Retrying(db, 3).Transaction(fn)
+type Retrier struct {
+	db   *gorm.DB
+	opts RetryOptions
+}
+
+// Retrying returns a Retrier making up to maxAttempts attempts at each transaction it runs, waiting between them as
+// RunWithRetry does
+func Retrying(db *gorm.DB, maxAttempts int) *Retrier {
+	return &Retrier{
+		db:   db,
+		opts: defaultRetryOptions(db, maxAttempts),
+	}
+}
+
+// Transaction runs fn in a transaction as db.Transaction(fn, opts...) does, retrying it for as long as it fails due to
+// concurrent modification in the same way as RunWithRetry
+func (r *Retrier) Transaction(fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	_, err := runWithRetry(r.db, r.opts, fn, opts...)
+	return err
+}
+
+// defaultRetryOptions returns the options RunWithRetry uses for the database, making up to maxAttempts attempts
+func defaultRetryOptions(db *gorm.DB, maxAttempts int) RetryOptions {
+	var opts RetryOptions
+	if plugin, ok := installedPlugin(db); ok {
+		opts = plugin.opts.Retry
+	}
+	opts.MaxAttempts = maxAttempts
+
+	return opts
+}
+
+// runWithRetry implements RunWithRetryStats, beginning each transaction with the given options
+func runWithRetry(db *gorm.DB, opts RetryOptions, fn func(*gorm.DB) error, txOpts ...*sql.TxOptions) (RetryStats, error) {
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var stats RetryStats
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			outcome := "conflicted"
+			if !IsConflict(err) {
+				outcome = "failed"
+			}
+			loggerFor(db).Debugf("optimistic: retrying after attempt %d of %d %s: %v", attempt, maxAttempts, outcome, err)
+			if sleepErr := sleepContext(ctx, opts.Delay(attempt-1)); sleepErr != nil {
+				return stats, sleepErr
+			}
+		}
+
+		attemptDB, record := recordConflicts(db)
+		err = attemptDB.Transaction(fn, txOpts...)
+		stats.Attempts++
+		stats.Conflicts += record.count
+		if !opts.retries(err) {
+			return stats, err
+		}
+		if record.count == 0 && IsConflict(err) {
+			stats.Conflicts++
+		}
+	}
+
+	return stats, &RetriesExhaustedError{
+		Attempts: maxAttempts,
+		Err:      err,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,198 @@
+package optimistic
+
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
+
+const (
+	forceUpdateKey         = "optimistic:force_update"
+	withoutVersionCheckKey = "optimistic:without_version_check"
+	explicitVersionKey     = "optimistic:explicit_version"
+	expectedVersionKey     = "optimistic:expected_version"
+	deleteVersionKey       = "optimistic:delete_version"
+	chainUpdatesKey        = "optimistic:chain_updates"
+	versionIncrementKey    = "optimistic:version_increment"
+	versionAheadKey        = "optimistic:version_ahead"
+)
+
+// ForceUpdate is a scope that makes updates and deletes apply regardless of the version the model was read at, for
+// privileged writes such as data repair, e.g. db.Scopes(optimistic.ForceUpdate).Updates(&model). The current version is
+// read from the database first so that the stored version still moves on from it, however the write is otherwise
+// unguarded and so would silently overwrite a write made between reading the version and applying the update.
+// It only affects statements built from the returned *gorm.DB
+func ForceUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Set(forceUpdateKey, true)
+}
+
+// isForced reports whether the statement was scoped with ForceUpdate
+func isForced(stmt *gorm.Statement) bool {
+	forced, ok := stmt.Settings.Load(forceUpdateKey)
+	return ok && forced == true
+}
+
+// WithoutVersionCheck returns a session in which updates and deletes are not checked for concurrent modification at
+// all, for bulk writes such as backfills and migrations, e.g. tx := optimistic.WithoutVersionCheck(db). As with a
+// checked write, each model's version is set to one past the version it was read at, however models that were never
+// read are written without changing their version
+func WithoutVersionCheck(db *gorm.DB) *gorm.DB {
+	return db.Set(withoutVersionCheckKey, true).Session(&gorm.Session{})
+}
+
+// isUnchecked reports whether the statement belongs to a session created by WithoutVersionCheck
+func isUnchecked(stmt *gorm.Statement) bool {
+	unchecked, ok := stmt.Settings.Load(withoutVersionCheckKey)
+	return ok && unchecked == true
+}
+
+// ChainUpdates returns a session in which each successful update of a model records the version it was written at as
+// the version the model was read at, e.g. tx := optimistic.ChainUpdates(db), so that the same in-memory model can be
+// updated again and again (say, by a debounced save) without being read in between. Outside such a session a model's
+// read version only moves on when it is read, so a further update of it conflicts with its own earlier write
+func ChainUpdates(db *gorm.DB) *gorm.DB {
+	return db.Set(chainUpdatesKey, true).Session(&gorm.Session{})
+}
+
+// isChained reports whether the statement belongs to a session created by ChainUpdates
+func isChained(stmt *gorm.Statement) bool {
+	chained, ok := stmt.Settings.Load(chainUpdatesKey)
+	return ok && chained == true
+}
+
+// ExplicitVersion is a scope that makes updates and soft deletes write the version the caller has set on the model,
+// rather than one past the version it was read at, for when versions are assigned elsewhere (e.g. the sequence numbers
+// of an event store), e.g. db.Scopes(optimistic.ExplicitVersion).Updates(&model). Writes are still guarded on the
+// version the model was read at, and fail with ErrVersionUnchanged if the model's version has not been changed, which
+// is always the case for fields tagged as versions since their value is taken to be the version they were read at
+func ExplicitVersion(tx *gorm.DB) *gorm.DB {
+	return tx.Set(explicitVersionKey, true)
+}
+
+// isExplicit reports whether the statement was scoped with ExplicitVersion
+func isExplicit(stmt *gorm.Statement) bool {
+	explicit, ok := stmt.Settings.Load(explicitVersionKey)
+	return ok && explicit == true
+}
+
+// AllowVersionAhead is a scope that makes updates and soft deletes write the version the caller has set on the model if
+// it is ahead of the version the model was read at, rather than overwriting it with one past the read version, e.g.
+// db.Scopes(optimistic.AllowVersionAhead).Updates(&model). Unlike ExplicitVersion a version left as it was read moves
+// on by one as usual, while a version behind the read version fails with ErrVersionBehind. Writes are still guarded on
+// the version the model was read at. Only Versioned models are affected, as other locks cannot tell whether the caller
+// has changed their value
+func AllowVersionAhead(tx *gorm.DB) *gorm.DB {
+	return tx.Set(versionAheadKey, true)
+}
+
+// allowsVersionAhead reports whether the statement was scoped with AllowVersionAhead
+func allowsVersionAhead(stmt *gorm.Statement) bool {
+	allowed, ok := stmt.Settings.Load(versionAheadKey)
+	return ok && allowed == true
+}
+
+// WithExpectedVersion guards updates and deletes on the given version, rather than the version the model was read at,
+// for request driven flows where the version a client was shown arrives separately from a model it has detached, e.g.
+// optimistic.WithExpectedVersion(db, version).Updates(&model). Each written model's read version is set to the given
+// version, just as SetReadVersion would. Models locked by something other than a version number cannot be written
+// with an expected version, and fail with ErrNoVersionNumber
+func WithExpectedVersion(tx *gorm.DB, version uint64) *gorm.DB {
+	return tx.Set(expectedVersionKey, version)
+}
+
+// applyExpectedVersion sets the read version of the model to that given to WithExpectedVersion, if any
+func applyExpectedVersion(stmt *gorm.Statement, l lock) error {
+	expected, ok := stmt.Settings.Load(expectedVersionKey)
+	if !ok {
+		return nil
+	}
+
+	switch l := l.(type) {
+	case *Versioned:
+		l.SetReadVersion(expected.(uint64))
+	case *fieldLock:
+		l.read = expected.(uint64)
+	default:
+		return ErrNoVersionNumber
+	}
+
+	return nil
+}
+
+// WithDeleteVersion makes soft deletes write the given version, rather than one past the version the model was read at,
+// for when the versions of deleted rows are sequenced elsewhere (e.g. tombstones numbered by an external sequence),
+// e.g. optimistic.WithDeleteVersion(db, version).Delete(&model). Deletes are still guarded on the version the model was
+// read at, and fail with ErrVersionUnchanged if given that version. Hard deletes write no version, so are unaffected,
+// and models locked by something other than a version number fail with ErrNoVersionNumber
+func WithDeleteVersion(tx *gorm.DB, version uint64) *gorm.DB {
+	return tx.Set(deleteVersionKey, version)
+}
+
+// applyDeleteVersion sets the in-memory version of the model to that given to WithDeleteVersion, if any, and makes the
+// statement write it just as ExplicitVersion would
+func applyDeleteVersion(stmt *gorm.Statement, l lock) error {
+	version, ok := stmt.Settings.Load(deleteVersionKey)
+	if !ok {
+		return nil
+	}
+
+	switch l := l.(type) {
+	case *Versioned:
+		l.Version = version.(uint64)
+	case *fieldLock:
+		if (l.signed && (version.(uint64) > math.MaxInt64 || l.value.OverflowInt(int64(version.(uint64))))) ||
+			(!l.signed && l.value.OverflowUint(version.(uint64))) {
+			return ErrVersionOverflow
+		}
+		l.set(version.(uint64))
+	default:
+		return ErrNoVersionNumber
+	}
+
+	stmt.Settings.Store(explicitVersionKey, true)
+	return nil
+}
+
+// WithVersionIncrement makes updates move the version on by delta, rather than by one, for keeping versions in step
+// with an external counter, e.g. optimistic.WithVersionIncrement(db, 5).Updates(&model). Updates are still guarded on
+// the version the model was read at. A delta of zero fails with ErrInvalidVersionIncrement, and models locked by
+// something other than a version number fail with ErrNoVersionNumber
+func WithVersionIncrement(tx *gorm.DB, delta uint64) *gorm.DB {
+	return tx.Set(versionIncrementKey, delta)
+}
+
+// applyVersionIncrement sets the in-memory version of the model to its read version plus the delta given to
+// WithVersionIncrement, if any, and makes the statement write it just as ExplicitVersion would
+func applyVersionIncrement(stmt *gorm.Statement, l lock) error {
+	loaded, ok := stmt.Settings.Load(versionIncrementKey)
+	if !ok {
+		return nil
+	}
+	delta := loaded.(uint64)
+	if delta < 1 {
+		return ErrInvalidVersionIncrement
+	}
+
+	switch l := l.(type) {
+	case *Versioned:
+		if l.readVersion > math.MaxUint64-delta {
+			return ErrVersionOverflow
+		}
+		l.Version = l.readVersion + delta
+	case *fieldLock:
+		if l.negative() {
+			return ErrNegativeVersion
+		}
+		version := l.read + delta
+		if version < l.read || (l.signed && (version > math.MaxInt64 || l.value.OverflowInt(int64(version)))) ||
+			(!l.signed && l.value.OverflowUint(version)) {
+			return ErrVersionOverflow
+		}
+		l.set(version)
+	default:
+		return ErrNoVersionNumber
+	}
+
+	stmt.Settings.Store(explicitVersionKey, true)
+	return nil
+}
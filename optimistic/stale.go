@@ -0,0 +1,60 @@
+package optimistic
+
+import (
+	"database/sql"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IsStale reports whether the model has been modified in the database since it was read, without loading the row or
+// writing to it - e.g. to warn a user before they try to save their changes. Only the lock column is compared, in the
+// same way writes are guarded, so a model that is not stale may still conflict if it is modified before it is written.
+// It returns gorm.ErrRecordNotFound if the row no longer exists, and ErrNotLocked if the model does not embed one of
+// this package's lock types
+func IsStale(tx *gorm.DB, model interface{}) (bool, error) {
+	l, ok := model.(lock)
+	if !ok {
+		return false, ErrNotLocked
+	}
+
+	// keep any table named with GORM's Table, which parsing the model only fills in if there is none
+	stmt := &gorm.Statement{DB: tx, Table: tx.Statement.Table}
+	if err := stmt.Parse(model); err != nil {
+		return false, err
+	}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+
+	current, err := lockMatches(tx, stmt, l)
+	if err != nil {
+		return false, err
+	}
+
+	return !current, nil
+}
+
+// lockMatches reports whether the row of the model currently being processed by the statement still holds the lock
+// value the model was read at, returning gorm.ErrRecordNotFound if the row no longer exists
+func lockMatches(tx *gorm.DB, stmt *gorm.Statement, l lock) (bool, error) {
+	conditions, ok := primaryKeyConditions(stmt)
+	if !ok {
+		return false, gorm.ErrPrimaryKeyRequired
+	}
+
+	query := tx.Session(&gorm.Session{NewDB: true})
+	// the row has to be read even when checking the writes of a DryRun, the session's configuration is its own copy
+	query.DryRun = false
+
+	var matches bool
+	err := query.Table(stmt.Table).
+		Select("? = ?", clause.Column{Name: l.lockColumn(stmt)}, l.readValue()).
+		Where(clause.And(conditions...)).Row().Scan(&matches)
+	if err == sql.ErrNoRows {
+		return false, gorm.ErrRecordNotFound
+	} else if err != nil {
+		return false, err
+	}
+
+	return matches, nil
+}
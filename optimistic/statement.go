@@ -0,0 +1,311 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// isSoftDeleteField reports whether the field soft deletes its model, as gorm.DeletedAt and other types providing
+// delete clauses (e.g. those of gorm.io/plugin/soft_delete) do
+func isSoftDeleteField(field *schema.Field) bool {
+	_, ok := reflect.New(field.IndirectFieldType).Interface().(schema.DeleteClausesInterface)
+	return ok
+}
+
+// isSaveUpdate reports whether the statement is the update GORM's Save issues for a model with a primary key, which
+// selects every column and falls back to creating the model if no rows were affected
+func isSaveUpdate(stmt *gorm.Statement) bool {
+	return len(stmt.Selects) == 1 && stmt.Selects[0] == "*"
+}
+
+// embeddedColumn returns the name of the column holding the named field of the embedded lock type, falling back to the
+// given default if the statement has no schema
+func embeddedColumn(stmt *gorm.Statement, lockType reflect.Type, fieldName string, defaultColumn string) string {
+	if stmt.Schema != nil {
+		for _, field := range stmt.Schema.Fields {
+			if field.Name == fieldName && field.OwnerSchema != nil && field.OwnerSchema.ModelType == lockType {
+				return field.DBName
+			}
+		}
+	}
+
+	return defaultColumn
+}
+
+// addGuard restricts the statement to rows where the lock column still holds the expected value
+func addGuard(stmt *gorm.Statement, column string, expected interface{}) {
+	groupConditions(stmt)
+	stmt.Where(clause.Eq{Column: clause.Column{Name: column}, Value: expected})
+}
+
+// groupConditions parenthesises the statement's existing conditions if they include an OR, as GORM's soft delete
+// does, so that conditions added afterwards apply to all of them - otherwise GORM would build e.g.
+// `a OR b AND version = 1`, which does not guard writes to rows matching a
+func groupConditions(stmt *gorm.Statement) {
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+
+	where, ok := c.Expression.(clause.Where)
+	if !ok || len(where.Exprs) < 2 {
+		return
+	}
+
+	for _, expr := range where.Exprs {
+		if or, ok := expr.(clause.OrConditions); ok && len(or.Exprs) == 1 {
+			where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+			c.Expression = where
+			stmt.Clauses["WHERE"] = c
+			return
+		}
+	}
+}
+
+// setLockValue makes the statement write the new value into the lock column
+func setLockValue(stmt *gorm.Statement, column string, value interface{}) {
+	stmt.AddClause(clause.Set{{Column: clause.Column{Name: column}, Value: value}})
+
+	// the lock column has to be written even if the update is restricted to other columns - not only to move the lock
+	// value on, but because some databases only count rows whose values actually changed as affected, which would make
+	// an update leaving every other column unchanged look like a conflict
+	selectColumn(stmt, column)
+
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		// GORM builds the SET clause of map based updates from the map alone, so the new value has to be part of it -
+		// but copy it first rather than surprising the caller by modifying theirs
+		withValue := make(map[string]interface{}, len(values)+1)
+		for key, value := range values {
+			withValue[key] = value
+		}
+		withValue[column] = value
+		stmt.Dest = withValue
+	}
+}
+
+// keepLockValue makes sure the write made by the statement writes the new lock value it was guarded with, if any.
+// GORM replaces the SET clause added by setLockValue, with one built from the statement's destination for updates
+// (which leaves out the zero valued fields of structs) and with one marking the row as deleted for soft deletes, so the
+// lock value is assigned again when the clause is built if it is missing
+func keepLockValue(stmt *gorm.Statement) {
+	loaded, ok := stmt.Settings.Load(guardKey)
+	if !ok || !loaded.(*writeGuard).advanced {
+		return
+	}
+	guard := loaded.(*writeGuard)
+	guard.assigned = true
+	assignment := clause.Assignment{Column: clause.Column{Name: guard.column}, Value: guard.value}
+
+	c := stmt.Clauses["SET"]
+	c.Name = "SET"
+	c.Builder = func(c clause.Clause, builder clause.Builder) {
+		c.Builder = nil
+		c.Expression = withAssignment(c.Expression, assignment)
+		c.Build(builder)
+	}
+	stmt.Clauses["SET"] = c
+}
+
+// withAssignment returns the SET clause expression with the assignment added, unless it already assigns the column
+func withAssignment(expression clause.Expression, assignment clause.Assignment) clause.Expression {
+	set, _ := expression.(clause.Set)
+	for _, existing := range set {
+		if existing.Column.Name == assignment.Column.Name {
+			return set
+		}
+	}
+	// copy rather than append in place, the clause may be shared with clones of the statement
+	return append(set[:len(set):len(set)], assignment)
+}
+
+// selectColumn makes sure that the statement writes the column, whichever columns it has selected or omitted
+func selectColumn(stmt *gorm.Statement, column string) {
+	matches := func(name string) bool {
+		if stmt.Schema != nil {
+			if field := stmt.Schema.LookUpField(name); field != nil {
+				return field.DBName == column
+			}
+		}
+		return name == column
+	}
+
+	omits := make([]string, 0, len(stmt.Omits))
+	for _, omit := range stmt.Omits {
+		if !matches(omit) {
+			omits = append(omits, omit)
+		}
+	}
+	stmt.Omits = omits
+
+	if len(stmt.Selects) == 0 {
+		return
+	}
+	for _, selected := range stmt.Selects {
+		if selected == "*" || matches(selected) {
+			return
+		}
+	}
+	// copy rather than append in place, the statement may share its selects with the *gorm.DB it was built from
+	stmt.Selects = append(stmt.Selects[:len(stmt.Selects):len(stmt.Selects)], column)
+}
+
+// identifiesRow reports whether the conditions GORM gives the statement from its destination match exactly one row,
+// which is only the case for a single model whose primary key has no zero values (GORM leaves those out)
+func identifiesRow(stmt *gorm.Statement) bool {
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 || rv.Kind() != reflect.Struct {
+		return false
+	}
+
+	for _, field := range stmt.Schema.PrimaryFields {
+		if _, isZero := field.ValueOf(rv); isZero {
+			return false
+		}
+	}
+	return true
+}
+
+// lockValueAdvanced reports whether the statement's write was guarded with a new lock value, see guardWrite
+func lockValueAdvanced(stmt *gorm.Statement) bool {
+	guard, ok := stmt.Settings.Load(guardKey)
+	return ok && guard.(*writeGuard).advanced
+}
+
+// lockValueAssigned reports whether the statement's own SET clause assigned the new lock value, see keepLockValue
+func lockValueAssigned(stmt *gorm.Statement) bool {
+	guard, ok := stmt.Settings.Load(guardKey)
+	return ok && guard.(*writeGuard).assigned
+}
+
+// persistSoftDeleteLockValue writes the new lock value for a soft deleted model whose soft delete could not assign it,
+// since GORM's soft delete replaces the SET clause added before the delete. The write is made through the hook's
+// session, and so uses the same connection (and transaction, if any) as the delete
+func persistSoftDeleteLockValue(tx *gorm.DB, column string, expected interface{}, value interface{}) error {
+	// workaround for GORM issue https://github.com/go-gorm/gorm/pull/3893#issuecomment-877706731
+	followUp := tx.Unscoped()
+	if conditions, ok := primaryKeyConditions(tx.Statement); ok {
+		// target exactly the deleted row, rather than everything matching the statement's destination, which may
+		// include other rows when deleting a slice or may not fully identify the row when part of its key is zero
+		followUp = followUp.Table(tx.Statement.Table).Where(clause.And(conditions...))
+	} else {
+		followUp = followUp.Model(tx.Statement.Dest).Table(tx.Statement.Table)
+	}
+
+	return followUp.Where(clause.Eq{Column: clause.Column{Name: column}, Value: expected}).UpdateColumn(column, value).Error
+}
+
+// tableSession returns a new session on the database for reading the rows written through the *gorm.DB, which reads
+// from the table named with GORM's Table if the *gorm.DB was given one, rather than the table of the model read into
+func tableSession(tx *gorm.DB) *gorm.DB {
+	query := tx.Session(&gorm.Session{NewDB: true})
+	if tx.Statement.Table != "" {
+		query = query.Table(tx.Statement.Table)
+	}
+	return query
+}
+
+// noRowsAffected reports whether the statement failed to modify any rows, i.e. whether its guard did not match
+func noRowsAffected(tx *gorm.DB) bool {
+	return rowsAffected(tx) < 1
+}
+
+// rowsAffected returns the number of rows modified by the statement. GORM records the count on the *gorm.DB executing
+// the statement, which is the statement's DB rather than the session hooks are given (GORM's method hooks are given a
+// new session sharing the statement, which never has a count of its own). The Plugin's callbacks copy the count onto
+// the sessions they give hooks, and a session that is itself the statement's DB holds the count directly
+func rowsAffected(tx *gorm.DB) int64 {
+	if tx.Statement.DB == nil || tx.Statement.DB == tx {
+		return tx.RowsAffected
+	}
+	if tx.RowsAffected > 0 {
+		return tx.RowsAffected
+	}
+	return tx.Statement.DB.RowsAffected
+}
+
+// newConflictError describes a conflict when performing the operation on the model currently being processed by the
+// statement
+func newConflictError(stmt *gorm.Statement, op Operation) *ConflictError {
+	return &ConflictError{
+		Operation:  op,
+		Table:      stmt.Table,
+		PrimaryKey: primaryKeyOf(stmt),
+		ifMatch:    stmt.Context != nil && stmt.Context.Value(ifMatchContextKey{}) != nil,
+	}
+}
+
+// primaryKeyOf returns the primary key of the model currently being processed by the statement, giving composite
+// primary keys as a []interface{}
+func primaryKeyOf(stmt *gorm.Statement) interface{} {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return nil
+	}
+
+	rv := currentReflectValue(stmt)
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if len(stmt.Schema.PrimaryFields) == 1 {
+		value, _ := stmt.Schema.PrimaryFields[0].ValueOf(rv)
+		return value
+	}
+
+	values := make([]interface{}, 0, len(stmt.Schema.PrimaryFields))
+	for _, field := range stmt.Schema.PrimaryFields {
+		value, _ := field.ValueOf(rv)
+		values = append(values, value)
+	}
+	return values
+}
+
+// primaryKeyConditions returns conditions matching the primary key of the model currently being processed by the
+// statement, reporting false if the model does not have a primary key (or its primary key is entirely zero)
+func primaryKeyConditions(stmt *gorm.Statement) ([]clause.Expression, bool) {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return nil, false
+	}
+
+	rv := currentReflectValue(stmt)
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	conditions := make([]clause.Expression, 0, len(stmt.Schema.PrimaryFields))
+	allZero := true
+	for _, field := range stmt.Schema.PrimaryFields {
+		value, isZero := field.ValueOf(rv)
+		allZero = allZero && isZero
+		conditions = append(conditions, clause.Eq{Column: clause.Column{Name: field.DBName}, Value: value})
+	}
+
+	return conditions, !allZero
+}
+
+// currentModel returns a pointer to the model currently being processed by the statement, or nil if it cannot be
+// addressed
+func currentModel(stmt *gorm.Statement) interface{} {
+	rv := currentReflectValue(stmt)
+	if !rv.IsValid() || !rv.CanAddr() {
+		return nil
+	}
+	return rv.Addr().Interface()
+}
+
+// currentReflectValue returns the (dereferenced) model currently being processed by the statement, which is the
+// current element when the statement operates on a slice
+func currentReflectValue(stmt *gorm.Statement) reflect.Value {
+	rv := reflect.Indirect(stmt.ReflectValue)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		if stmt.CurDestIndex >= rv.Len() {
+			return reflect.Value{}
+		}
+		rv = reflect.Indirect(rv.Index(stmt.CurDestIndex))
+	}
+	return rv
+}
@@ -0,0 +1,37 @@
+package optimistic
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+)
+
+var versionTokenEncoding = base64.RawURLEncoding.Strict()
+
+// MarshalVersionToken returns the model's version as a compact opaque token, for clients to echo back (say, in an
+// If-Match header) so that the read version can be restored with ParseVersionToken and SetReadVersion
+func (v *Versioned) MarshalVersionToken() string {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v.Version)
+	return versionTokenEncoding.EncodeToString(buf[:n])
+}
+
+// ParseVersionToken returns the version encoded in a token produced by MarshalVersionToken, or
+// ErrMalformedVersionToken if it is not such a token
+func ParseVersionToken(token string) (uint64, error) {
+	buf, err := versionTokenEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrMalformedVersionToken
+	}
+
+	version, n := binary.Uvarint(buf)
+	if n <= 0 || n != len(buf) {
+		return 0, ErrMalformedVersionToken
+	}
+
+	// only accept the shortest encoding of the version, so that each version has exactly one token
+	if n > 1 && buf[n-1] == 0 {
+		return 0, ErrMalformedVersionToken
+	}
+
+	return version, nil
+}
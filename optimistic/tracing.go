@@ -0,0 +1,84 @@
+package optimistic
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+const spanKey = "optimistic:span"
+
+// Span attributes set on the spans the Plugin starts around guarded writes
+const (
+	// SpanAttributeTable is the table written to
+	SpanAttributeTable = "optimistic.table"
+	// SpanAttributeOperation is the kind of write, as given by Operation's String method
+	SpanAttributeOperation = "optimistic.operation"
+	// SpanAttributeExpectedVersion is the lock value the write was guarded on
+	SpanAttributeExpectedVersion = "optimistic.expected_version"
+	// SpanAttributeConflict is whether the write conflicted
+	SpanAttributeConflict = "optimistic.conflict"
+)
+
+// Tracer starts the spans the Plugin creates around guarded writes, so that conflicts appear in traces. It is satisfied
+// by a thin adapter over a tracing library's tracer (e.g. OpenTelemetry's), keeping this package free of a dependency
+// on one
+type Tracer interface {
+	// Start starts a span with the given name as a child of any span in ctx, returning a context holding the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetAttribute records an attribute of the span
+	SetAttribute(key string, value interface{})
+	// End ends the span
+	End()
+}
+
+// startSpan starts a span around the guarded write made by the statement, if the Plugin has a Tracer. The statement's
+// context is replaced by the span's, so that spans started for the write's SQL are children of it
+func (p *Plugin) startSpan(op func(stmt *gorm.Statement) Operation) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if p.opts.Tracer == nil || db.Error != nil {
+			return
+		}
+		loaded, ok := stmt.Settings.Load(guardKey)
+		if !ok {
+			return
+		}
+		guard := loaded.(*writeGuard)
+
+		ctx := stmt.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		operation := op(stmt)
+		ctx, span := p.opts.Tracer.Start(ctx, "optimistic: "+operation.String())
+		span.SetAttribute(SpanAttributeTable, stmt.Table)
+		span.SetAttribute(SpanAttributeOperation, operation.String())
+		span.SetAttribute(SpanAttributeExpectedVersion, guard.expected)
+
+		stmt.Context = ctx
+		stmt.Settings.Store(spanKey, span)
+	}
+}
+
+// endSpan records whether the write conflicted on the span started by startSpan, if any, then ends it
+func endSpan(db *gorm.DB) {
+	loaded, ok := db.Statement.Settings.Load(spanKey)
+	if !ok {
+		return
+	}
+	db.Statement.Settings.Delete(spanKey)
+
+	span := loaded.(Span)
+	span.SetAttribute(SpanAttributeConflict, IsConflict(db.Error))
+	span.End()
+}
+
+// updateOperation is the operation of every update, for startSpan
+func updateOperation(*gorm.Statement) Operation {
+	return OperationUpdate
+}
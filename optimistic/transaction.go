@@ -0,0 +1,62 @@
+package optimistic
+
+import (
+	"database/sql"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	conflictRecordKey = "optimistic:conflict_record"
+)
+
+// conflictRecord records the conflicts detected by writes made within a Transaction (or an attempt made by
+// RunWithRetry), along with those of any enclosing one
+type conflictRecord struct {
+	last   *ConflictError
+	count  int
+	parent *conflictRecord
+}
+
+// Transaction runs fn in a transaction exactly as db.Transaction(fn, opts...) does, committing if it succeeds and
+// rolling back if it fails or panics, but if fn fails with a bare ErrConcurrentModification (say, because it was
+// returned in place of the error from a write) the ConflictError describing the most recent conflicting write made
+// within the transaction is returned instead. Other errors, and conflicts where no write made within the transaction
+// conflicted, are returned unchanged. Conflicts are counted in the metrics when they are detected, as they would be
+// without a Transaction
+func Transaction(db *gorm.DB, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	db, record := recordConflicts(db)
+	err := db.Transaction(fn, opts...)
+
+	var conflict *ConflictError
+	if IsConflict(err) && !errors.As(err, &conflict) && record.last != nil {
+		return record.last
+	}
+
+	return err
+}
+
+// recordConflicts returns a *gorm.DB recording the conflicts detected by the writes made through it
+func recordConflicts(db *gorm.DB) (*gorm.DB, *conflictRecord) {
+	record := &conflictRecord{}
+	if parent, ok := db.Statement.Settings.Load(conflictRecordKey); ok {
+		record.parent = parent.(*conflictRecord)
+	}
+
+	return db.Set(conflictRecordKey, record), record
+}
+
+// recordTransactionConflict records the conflict if it was detected by a write made through a *gorm.DB returned by
+// recordConflicts
+func recordTransactionConflict(stmt *gorm.Statement, err *ConflictError) {
+	recorded, ok := stmt.Settings.Load(conflictRecordKey)
+	if !ok {
+		return
+	}
+
+	for record := recorded.(*conflictRecord); record != nil; record = record.parent {
+		record.last = err
+		record.count++
+	}
+}
@@ -0,0 +1,86 @@
+package optimistic
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotLocked is returned by UpdateColumnVersioned and IsStale when the model does not embed one of this package's
+// lock types
+var ErrNotLocked = errors.New("model is not optimistically locked")
+
+// ErrNotCounter is returned by AtomicAdd when the column is not an integer field of the model
+var ErrNotCounter = errors.New("column is not an integer field of the model")
+
+// UpdateColumnVersioned updates a single column of the statement's model like GORM's UpdateColumn, skipping hooks and
+// leaving the update time alone, but still only applies if there has not been a concurrent modification and moves the
+// lock on, e.g. optimistic.UpdateColumnVersioned(db.Model(&model), "name", "hello")
+func UpdateColumnVersioned(tx *gorm.DB, column string, value interface{}) error {
+	model := tx.Statement.Model
+	l, ok := model.(lock)
+	if !ok {
+		return ErrNotLocked
+	}
+
+	update := tx.Model(model)
+	stmt := update.Statement
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	stmt.Dest = map[string]interface{}{column: value}
+	stmt.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+
+	if err := applyExpectedVersion(stmt, l); err != nil {
+		return err
+	}
+	if err := guardWrite(update, l, true); err != nil {
+		return err
+	}
+
+	update = update.UpdateColumns(stmt.Dest)
+	if update.Error != nil {
+		return update.Error
+	}
+
+	return ensureRowsAffected(update, l, OperationUpdate)
+}
+
+// AtomicAdd adds delta to an integer column of the model in a single statement, e.g. to count page views with
+// optimistic.AtomicAdd(db, &page, "views", 1). As with UpdateColumnVersioned it skips hooks, only applies if there has
+// not been a concurrent modification and moves the lock on, and once applied the model's field holds the new total
+func AtomicAdd(tx *gorm.DB, model interface{}, column string, delta int64) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	field := stmt.Schema.LookUpField(column)
+	rv := reflect.Indirect(reflect.ValueOf(model))
+	if field == nil || !rv.CanAddr() {
+		return ErrNotCounter
+	}
+	value := field.ReflectValueOf(rv)
+
+	// the guard only matches if the column still holds the value the model was read with, so once the write is made
+	// the new total is the model's value plus delta
+	var add func()
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		add = func() { value.SetInt(value.Int() + delta) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		add = func() { value.SetUint(value.Uint() + uint64(delta)) }
+	default:
+		return ErrNotCounter
+	}
+
+	sum := gorm.Expr("? + ?", clause.Column{Name: field.DBName}, delta)
+	if err := UpdateColumnVersioned(tx.Model(model), field.DBName, sum); err != nil {
+		return err
+	}
+	add()
+
+	return nil
+}
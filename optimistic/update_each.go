@@ -0,0 +1,54 @@
+package optimistic
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// UpdateEach updates each of the models in turn, exactly as tx.Updates(model) would, in a single transaction. GORM's
+// Updates of a slice of models builds one statement guarded on the versions of every model at once, which cannot tell
+// which of them conflicted, whereas UpdateEach guards each model on its own version. Models that have been modified
+// since they were read are left unwritten and returned as conflicts, keyed by primary key (as given by
+// ConflictError.PrimaryKey, except that composite primary keys, which cannot be map keys, are formatted with
+// fmt.Sprint), and the rest are written. Any other error rolls back every update, and returns the models written
+// before it to the state they were in before UpdateEach was called
+func UpdateEach[T any](tx *gorm.DB, models []*T) (map[interface{}]*ConflictError, error) {
+	conflicts := map[interface{}]*ConflictError{}
+	written := make(map[*T]T, len(models))
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		for _, model := range models {
+			previous := *model
+			err := tx.Updates(model).Error
+
+			var conflict *ConflictError
+			switch {
+			case err == nil:
+				written[model] = previous
+			case IsConflict(err) && errors.As(err, &conflict):
+				conflicts[conflictKey(conflict.PrimaryKey)] = conflict
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for model, previous := range written {
+			*model = previous
+		}
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// conflictKey returns the primary key of a conflicting model as a map key
+func conflictKey(primaryKey interface{}) interface{} {
+	if values, ok := primaryKey.([]interface{}); ok {
+		return fmt.Sprint(values)
+	}
+	return primaryKey
+}
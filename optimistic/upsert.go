@@ -0,0 +1,107 @@
+package optimistic
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+)
+
+// upsertClause returns the statement's ON CONFLICT clause, reporting whether it updates the conflicting row
+func upsertClause(stmt *gorm.Statement) (clause.OnConflict, bool) {
+	c, ok := stmt.Clauses["ON CONFLICT"]
+	if !ok {
+		return clause.OnConflict{}, false
+	}
+
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	return onConflict, ok && !onConflict.DoNothing && (onConflict.UpdateAll || len(onConflict.DoUpdates) > 0)
+}
+
+// beforeCreate makes an upsert, i.e. a create with an ON CONFLICT clause that updates the conflicting row, move the
+// version of the existing row on rather than setting it to the version being created. The update is not guarded, there
+// being no version it was read at to check
+func beforeCreate(tx *gorm.DB, l lock) error {
+	stmt := tx.Statement
+	onConflict, ok := upsertClause(stmt)
+	if !ok {
+		return nil
+	}
+
+	if onConflict.UpdateAll {
+		// GORM expands UpdateAll into an assignment for every column when it builds the statement, which would set the
+		// version back to the one being created, so have it expand it now and adjust the result instead
+		callbacks.ConvertToCreateValues(stmt)
+		onConflict, _ = upsertClause(stmt)
+		onConflict.UpdateAll = false
+	}
+
+	column := l.lockColumn(stmt)
+	updates := make(clause.Set, 0, len(onConflict.DoUpdates)+1)
+	for _, assignment := range onConflict.DoUpdates {
+		if assignment.Column.Name != column {
+			updates = append(updates, assignment)
+		}
+	}
+	onConflict.DoUpdates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: column},
+		Value:  gorm.Expr("? + 1", clause.Column{Table: stmt.Table, Name: column}),
+	})
+	stmt.AddClause(onConflict)
+
+	return nil
+}
+
+// ignoresConflicts reports whether the statement is a create with an ON CONFLICT clause that does nothing, leaving any
+// conflicting row as it is
+func ignoresConflicts(stmt *gorm.Statement) bool {
+	c, ok := stmt.Clauses["ON CONFLICT"]
+	if !ok {
+		return false
+	}
+
+	onConflict, ok := c.Expression.(clause.OnConflict)
+	return ok && onConflict.DoNothing
+}
+
+// skippedExisting reports whether a create that ignores conflicts left out any of its models because their rows
+// already existed
+func skippedExisting(stmt *gorm.Statement) bool {
+	models := int64(1)
+	if rv := stmt.ReflectValue; rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		models = int64(rv.Len())
+	}
+	return ignoresConflicts(stmt) && stmt.DB.RowsAffected < models
+}
+
+// afterCreate records the version each created model is at, which for an upsert that updated an existing row is the
+// version that row was moved on to rather than the version the model was created with, and for a create that left an
+// existing row as it was is the version of that row
+func afterCreate(tx *gorm.DB, l lock) error {
+	if _, ok := dryRunReport(tx.Statement); tx.Error != nil || ok {
+		return nil
+	}
+
+	if _, ok := upsertClause(tx.Statement); ok || skippedExisting(tx.Statement) {
+		err := refreshLockValue(tx, l, l.lockColumn(tx.Statement))
+		if err != gorm.ErrPrimaryKeyRequired {
+			return err
+		}
+	}
+
+	return afterRead(tx, l)
+}
+
+// CreateIfAbsent creates the model unless a row with the same primary key (or another unique key) already exists,
+// reporting whether it was created. If the row already existed it is left as it was, and the model takes on the version
+// of that row (found by the model's primary key), so that later writes of the model are guarded on it. Its other fields
+// are not reloaded, e.g. call Reload if inserted is false for inserted, err := optimistic.CreateIfAbsent(db, &model)
+func CreateIfAbsent(tx *gorm.DB, model interface{}) (bool, error) {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(model)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// ParentModel is a Versioned model with children that are not versioned themselves
+type ParentModel struct {
+	gorm.Model
+	optimistic.Versioned
+
+	Value    int
+	Children []ChildModel          `gorm:"foreignKey:ParentID"`
+	Versions []VersionedChildModel `gorm:"foreignKey:ParentID"`
+}
+
+type ChildModel struct {
+	gorm.Model
+
+	ParentID uint
+	Name     string
+}
+
+type VersionedChildModel struct {
+	gorm.Model
+	optimistic.Versioned
+
+	ParentID uint
+	Name     string
+}
+
+var _ = Describe("Saving associations", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var usePlugin bool
+	var fullSave *gorm.DB
+	var parent *ParentModel
+
+	BeforeEach(func() {
+		usePlugin = false
+	})
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		if usePlugin {
+			Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		}
+		Expect(db.AutoMigrate(&ParentModel{}, &ChildModel{}, &VersionedChildModel{})).To(Succeed())
+		fullSave = db.Session(&gorm.Session{FullSaveAssociations: true})
+
+		Expect(db.Create(&ParentModel{
+			Model:    gorm.Model{ID: TestID},
+			Value:    100,
+			Children: []ChildModel{{Name: "first"}},
+			Versions: []VersionedChildModel{{Name: "first"}},
+		}).Error).To(Succeed())
+
+		parent = &ParentModel{}
+		Expect(db.Preload("Children").Preload("Versions").First(parent, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	load := func() *ParentModel {
+		persisted := &ParentModel{}
+		Expect(db.Preload("Children").Preload("Versions").First(persisted, TestID).Error).To(Succeed())
+		return persisted
+	}
+
+	behavesLikeAssociations := func() {
+		It("moves the parent's version on exactly once", func() {
+			parent.Value = 200
+			parent.Children[0].Name = "renamed"
+			parent.Children = append(parent.Children, ChildModel{Name: "second"})
+			Expect(fullSave.Updates(parent).Error).To(Succeed())
+			Expect(parent.Version).To(BeNumerically("==", 2))
+
+			persisted := load()
+			Expect(persisted.Value).To(Equal(200))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Children).To(HaveLen(2))
+			Expect(persisted.Children[0].Name).To(Equal("renamed"))
+		})
+
+		It("moves the parent's version on exactly once when saved", func() {
+			parent.Value = 200
+			parent.Children[0].Name = "renamed"
+			Expect(fullSave.Save(parent).Error).To(Succeed())
+
+			persisted := load()
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Children[0].Name).To(Equal("renamed"))
+		})
+
+		It("moves versioned children on separately from their parent", func() {
+			parent.Versions[0].Name = "renamed"
+			parent.Versions = append(parent.Versions, VersionedChildModel{Name: "second"})
+			Expect(fullSave.Updates(parent).Error).To(Succeed())
+
+			persisted := load()
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Versions).To(HaveLen(2))
+			Expect(persisted.Versions[0].Name).To(Equal("renamed"))
+			Expect(persisted.Versions[0].Version).To(BeNumerically("==", 2))
+			Expect(persisted.Versions[1].Version).To(BeNumerically("==", 1))
+		})
+
+		It("does not write the children of a conflicting update made in a transaction", func() {
+			other := &ParentModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			Expect(fullSave.Transaction(func(tx *gorm.DB) error {
+				parent.Value = 200
+				parent.Children[0].Name = "renamed"
+				return tx.Updates(parent).Error
+			})).To(MatchError(optimistic.ErrConcurrentModification))
+
+			persisted := load()
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Children[0].Name).To(Equal("first"))
+		})
+	}
+
+	behavesLikeAssociations()
+
+	When("the plugin is installed", func() {
+		BeforeEach(func() {
+			usePlugin = true
+		})
+
+		behavesLikeAssociations()
+
+		It("does not write the children of a conflicting update", func() {
+			other := &ParentModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			parent.Value = 200
+			parent.Children[0].Name = "renamed"
+			parent.Children = append(parent.Children, ChildModel{Name: "second"})
+			Expect(fullSave.Updates(parent).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+			persisted := load()
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Children).To(HaveLen(1))
+			Expect(persisted.Children[0].Name).To(Equal("first"))
+		})
+	})
+})
+
+var _ = Describe("UpdateAssociation", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var parent *ParentModel
+
+	describeUpdateAssociation := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&ParentModel{}, &ChildModel{}, &VersionedChildModel{})).To(Succeed())
+
+			Expect(db.Create(&ParentModel{
+				Model:    gorm.Model{ID: TestID},
+				Value:    100,
+				Children: []ChildModel{{Name: "first"}},
+			}).Error).To(Succeed())
+
+			parent = &ParentModel{}
+			Expect(db.Preload("Children").First(parent, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		load := func() *ParentModel {
+			persisted := &ParentModel{}
+			Expect(db.Preload("Children").First(persisted, TestID).Error).To(Succeed())
+			return persisted
+		}
+
+		It("moves the parent's version on exactly once when replacing an association", func() {
+			Expect(optimistic.UpdateAssociation(db, parent, "Children", func(a *gorm.Association) error {
+				return a.Replace(&ChildModel{Name: "second"})
+			})).To(Succeed())
+			Expect(parent.Version).To(BeNumerically("==", 2))
+
+			persisted := load()
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Children).To(HaveLen(1))
+			Expect(persisted.Children[0].Name).To(Equal("second"))
+		})
+
+		It("moves the parent's version on when clearing an association", func() {
+			Expect(optimistic.UpdateAssociation(db, parent, "Children", func(a *gorm.Association) error {
+				return a.Clear()
+			})).To(Succeed())
+			Expect(parent.Version).To(BeNumerically("==", 2))
+
+			persisted := load()
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Children).To(BeEmpty())
+		})
+
+		It("does not change the association of a concurrently modified parent", func() {
+			other := &ParentModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			for _, change := range []func(a *gorm.Association) error{
+				func(a *gorm.Association) error { return a.Replace(&ChildModel{Name: "second"}) },
+				func(a *gorm.Association) error { return a.Clear() },
+			} {
+				err := optimistic.UpdateAssociation(db, parent, "Children", change)
+				Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+				persisted := load()
+				Expect(persisted.Version).To(BeNumerically("==", 2))
+				Expect(persisted.Children).To(HaveLen(1))
+				Expect(persisted.Children[0].Name).To(Equal("first"))
+			}
+		})
+
+		It("rejects models that are not locked", func() {
+			child := &ChildModel{}
+			Expect(optimistic.UpdateAssociation(db, child, "Parent", func(a *gorm.Association) error {
+				return a.Clear()
+			})).To(MatchError(optimistic.ErrNotLocked))
+		})
+	}
+
+	describeUpdateAssociation(false)
+
+	When("using the plugin", func() {
+		describeUpdateAssociation(true)
+	})
+})
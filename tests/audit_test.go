@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"errors"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Version auditing", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var opts optimistic.PluginOptions
+	var m *TestModel
+
+	BeforeEach(func() {
+		opts = optimistic.PluginOptions{AuditVersions: true}
+	})
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(opts))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(optimistic.MigrateVersionAudit(db, &TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	auditRecords := func(table string) []optimistic.VersionAudit {
+		var records []optimistic.VersionAudit
+		Expect(db.Table(table).Order("id").Find(&records).Error).To(Succeed())
+		return records
+	}
+
+	It("records one row per update", func() {
+		for value := 200; value <= 400; value += 100 {
+			m.Value = value
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		}
+
+		records := auditRecords("test_models_versions")
+		Expect(records).To(HaveLen(3))
+		for i, record := range records {
+			Expect(record.PrimaryKey).To(Equal("1"))
+			Expect(record.Operation).To(Equal("update"))
+			Expect(record.OldVersion).To(Equal(strconv.Itoa(i + 1)))
+			Expect(record.NewVersion).To(Equal(strconv.Itoa(i + 2)))
+			Expect(record.Timestamp).NotTo(BeZero())
+		}
+	})
+
+	It("records deletes", func() {
+		Expect(db.Delete(m).Error).To(Succeed())
+		Expect(db.Unscoped().First(m, TestID).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(m).Error).To(Succeed())
+
+		records := auditRecords("test_models_versions")
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Operation).To(Equal("soft delete"))
+		Expect(records[0].OldVersion).To(Equal("1"))
+		Expect(records[0].NewVersion).To(Equal("2"))
+		Expect(records[1].Operation).To(Equal("hard delete"))
+		Expect(records[1].OldVersion).To(Equal("2"))
+		Expect(records[1].NewVersion).To(BeEmpty())
+	})
+
+	It("does not record conflicting writes", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		m.Value = 300
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(auditRecords("test_models_versions")).To(HaveLen(1))
+	})
+
+	It("records writes in the same transaction", func() {
+		errRollback := errors.New("rollback")
+		Expect(db.Transaction(func(tx *gorm.DB) error {
+			m.Value = 200
+			Expect(tx.Updates(m).Error).To(Succeed())
+			return errRollback
+		})).To(MatchError(errRollback))
+
+		Expect(auditRecords("test_models_versions")).To(BeEmpty())
+	})
+
+	When("the audit table name is configured", func() {
+		BeforeEach(func() {
+			opts.AuditTableName = func(table string) string {
+				return "audit_" + table
+			}
+		})
+
+		It("records writes in the named table", func() {
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+
+			Expect(auditRecords("audit_test_models")).To(HaveLen(1))
+			Expect(db.Migrator().HasTable("test_models_versions")).To(BeFalse())
+		})
+	})
+
+	When("auditing is not enabled", func() {
+		BeforeEach(func() {
+			opts.AuditVersions = false
+		})
+
+		It("records nothing", func() {
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+
+			Expect(auditRecords("test_models_versions")).To(BeEmpty())
+		})
+	})
+})
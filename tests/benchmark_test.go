@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// openBenchmarkDB creates a fresh sqlite database in a temporary directory, counting the statements executed on it
+func openBenchmarkDB(b *testing.B) (*gorm.DB, *statementCounter) {
+	tempDir, err := ioutil.TempDir("", "benchmarks-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	counter := newStatementCounter()
+	db, err := gorm.Open(sqlite.Open(path.Join(tempDir, "test.sqlite3")), &gorm.Config{
+		Logger:                 counter,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&TestModel{}); err != nil {
+		b.Fatal(err)
+	}
+	return db, counter
+}
+
+// BenchmarkSoftDelete soft deletes models, reporting the statements each delete executes: one for a single model, whose
+// version is written by the soft delete itself, and two for a slice, which is followed by an update of the version
+func BenchmarkSoftDelete(b *testing.B) {
+	benchmark := func(b *testing.B, del func(db *gorm.DB, m *TestModel) error) {
+		db, counter := openBenchmarkDB(b)
+
+		var statements int64
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			m := &TestModel{Model: gorm.Model{ID: uint(i + 1)}, Value: 100}
+			if err := db.Create(m).Error; err != nil {
+				b.Fatal(err)
+			}
+			before := counter.Count()
+			b.StartTimer()
+
+			if err := del(db, m); err != nil {
+				b.Fatal(err)
+			}
+			statements += counter.Count() - before
+		}
+
+		b.ReportMetric(float64(statements)/float64(b.N), "statements/op")
+	}
+
+	b.Run("single model", func(b *testing.B) {
+		benchmark(b, func(db *gorm.DB, m *TestModel) error {
+			return db.Delete(m).Error
+		})
+	})
+
+	b.Run("slice", func(b *testing.B) {
+		benchmark(b, func(db *gorm.DB, m *TestModel) error {
+			return db.Delete(&[]*TestModel{m}).Error
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("BulkUpdate", func() {
+	const count = 10
+
+	var db *gorm.DB
+	var closeDB func()
+	var models []*TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		models = nil
+		for id := uint(1); id <= count; id++ {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: id}, Value: 100}).Error).To(Succeed())
+
+			m := &TestModel{}
+			Expect(db.First(m, id).Error).To(Succeed())
+			models = append(models, m)
+		}
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("writes every model", func() {
+		for i, m := range models {
+			m.Value = 200 + i
+		}
+
+		Expect(optimistic.BulkUpdate(db, models)).To(BeEmpty())
+
+		for i, m := range models {
+			Expect(m.Version).To(BeNumerically("==", 2))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, m.ID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(200 + i))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.UpdatedAt).To(BeTemporally("~", m.UpdatedAt))
+		}
+	})
+
+	It("reports exactly the stale models as conflicts, writing the rest", func() {
+		stale := map[uint]bool{}
+		for _, m := range models {
+			if m.ID%2 == 0 {
+				other := &TestModel{}
+				Expect(db.First(other, m.ID).Error).To(Succeed())
+				other.Value = 300
+				Expect(db.Updates(other).Error).To(Succeed())
+				stale[m.ID] = true
+			}
+			m.Value = 200
+		}
+
+		failures, err := optimistic.BulkUpdate(db, models)
+		Expect(err).To(Succeed())
+
+		var conflicted []uint
+		for _, failure := range failures {
+			Expect(failure).To(MatchError(optimistic.ErrConcurrentModification))
+
+			var conflict *optimistic.ConflictError
+			Expect(errors.As(failure, &conflict)).To(BeTrue())
+			Expect(conflict.Operation).To(Equal(optimistic.OperationUpdate))
+			Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+			conflicted = append(conflicted, conflict.PrimaryKey.(uint))
+		}
+		Expect(conflicted).To(ConsistOf(uint(2), uint(4), uint(6), uint(8), uint(10)))
+
+		for _, m := range models {
+			persisted := &TestModel{}
+			Expect(db.First(persisted, m.ID).Error).To(Succeed())
+			if stale[m.ID] {
+				Expect(m.Version).To(BeNumerically("==", 1))
+				Expect(persisted.Value).To(Equal(300))
+			} else {
+				Expect(m.Version).To(BeNumerically("==", 2))
+				Expect(persisted.Value).To(Equal(200))
+			}
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+		}
+	})
+
+	It("reports deleted models as missing rows rather than conflicts", func() {
+		other := &TestModel{}
+		Expect(db.First(other, models[0].ID).Error).To(Succeed())
+		Expect(db.Delete(other).Error).To(Succeed())
+
+		failures, err := optimistic.BulkUpdate(db, models)
+		Expect(err).To(Succeed())
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0]).To(MatchError(gorm.ErrRecordNotFound))
+		Expect(optimistic.IsConflict(failures[0])).To(BeFalse())
+
+		var missing *optimistic.MissingRowError
+		Expect(errors.As(failures[0], &missing)).To(BeTrue())
+		Expect(missing.Operation).To(Equal(optimistic.OperationUpdate))
+		Expect(missing.PrimaryKey).To(Equal(models[0].ID))
+		Expect(models[0].Version).To(BeNumerically("==", 1))
+	})
+
+	It("writes nothing and leaves the models as they were if the batch fails", func() {
+		// auditing without migrating the audit table fails the batch once its rows have been written
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{AuditVersions: true}))).To(Succeed())
+
+		for _, m := range models {
+			m.Value = 200
+		}
+		updatedAt := models[0].UpdatedAt
+
+		_, err := optimistic.BulkUpdate(db, models)
+		Expect(err).To(HaveOccurred())
+
+		for _, m := range models {
+			Expect(m.Value).To(Equal(200))
+			Expect(m.Version).To(BeNumerically("==", 1))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, m.ID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(100))
+			Expect(persisted.Version).To(BeNumerically("==", 1))
+		}
+		Expect(models[0].UpdatedAt).To(Equal(updatedAt))
+
+		// the models can still be written once the failure is resolved
+		Expect(optimistic.MigrateVersionAudit(db, &TestModel{})).To(Succeed())
+		Expect(optimistic.BulkUpdate(db, models)).To(BeEmpty())
+		Expect(models[0].Version).To(BeNumerically("==", 2))
+	})
+
+	It("writes nothing if any model was never read", func() {
+		for _, m := range models {
+			m.Value = 200
+		}
+		unread := &TestModel{Model: gorm.Model{ID: models[0].ID}, Value: 300}
+
+		_, err := optimistic.BulkUpdate(db, append(models, unread))
+		Expect(err).To(MatchError(optimistic.ErrUnknownReadVersion))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, models[1].ID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(100))
+	})
+
+	It("requires a locked model", func() {
+		_, err := optimistic.BulkUpdate(db, []*UnlockedModel{{ID: TestID}})
+		Expect(err).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("ChainUpdates", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeChainUpdates := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func() *TestModel {
+			p := &TestModel{}
+			Expect(db.First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("updates the same in-memory model repeatedly without reloading it", func() {
+			tx := optimistic.ChainUpdates(db)
+			for i := 1; i <= 3; i++ {
+				m.Value = 100 + i
+				Expect(tx.Updates(m).Error).To(Succeed())
+				Expect(m.Version).To(BeNumerically("==", 1+i))
+				Expect(m.ReadVersion()).To(BeNumerically("==", 1+i))
+			}
+
+			p := persisted()
+			Expect(p.Value).To(Equal(103))
+			Expect(p.Version).To(BeNumerically("==", 4))
+		})
+
+		It("still detects concurrent modification between updates", func() {
+			tx := optimistic.ChainUpdates(db)
+			m.Value = 101
+			Expect(tx.Updates(m).Error).To(Succeed())
+
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 200
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			m.Value = 102
+			Expect(tx.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+			Expect(persisted().Value).To(Equal(200))
+		})
+
+		It("does not chain updates outside the session", func() {
+			m.Value = 101
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+			m.Value = 102
+			Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+	}
+
+	describeChainUpdates(false)
+
+	When("using the plugin", func() {
+		describeChainUpdates(true)
+	})
+})
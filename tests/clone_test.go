@@ -0,0 +1,53 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Clone", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("copies the version the model was read at", func() {
+		clone := optimistic.Clone(m)
+		Expect(clone).NotTo(BeIdenticalTo(m))
+		Expect(clone.Version).To(Equal(m.Version))
+		Expect(clone.ReadVersion()).To(Equal(m.ReadVersion()))
+	})
+
+	It("guards writes of each copy on the version the original was read at", func() {
+		a := optimistic.Clone(m)
+		b := optimistic.Clone(m)
+
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+		Expect(a.Version).To(BeNumerically("==", 2))
+		Expect(b.Version).To(BeNumerically("==", 1))
+
+		b.Value = 300
+		Expect(db.Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(m.Value).To(Equal(100))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+	})
+})
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type RowVersionModel struct {
+	gorm.Model
+	optimistic.Versioned `gorm:"embeddedPrefix:row_"`
+
+	Value int
+}
+
+var _ = Describe("Custom version column", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *RowVersionModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&RowVersionModel{})).To(Succeed())
+
+		m = &RowVersionModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(m).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("migrates the renamed column", func() {
+		Expect(db.Migrator().HasColumn(&RowVersionModel{}, "row_version")).To(BeTrue())
+		Expect(db.Migrator().HasColumn(&RowVersionModel{}, "version")).To(BeFalse())
+	})
+
+	It("persists an incremented version on update", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		var version uint64
+		Expect(db.Model(&RowVersionModel{}).Where("id = ?", TestID).Pluck("row_version", &version).Error).To(Succeed())
+		Expect(version).To(BeNumerically("==", 2))
+	})
+
+	It("persists an incremented version on soft deletion", func() {
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		var version uint64
+		Expect(db.Unscoped().Model(&RowVersionModel{}).Where("id = ?", TestID).Pluck("row_version", &version).Error).
+			To(Succeed())
+		Expect(version).To(BeNumerically("==", 2))
+	})
+
+	It("detects concurrent modification", func() {
+		a := &RowVersionModel{}
+		b := &RowVersionModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		Expect(db.First(b, TestID).Error).To(Succeed())
+
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+
+		b.Value = 300
+		Expect(db.Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+})
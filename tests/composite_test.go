@@ -0,0 +1,89 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type CompositeModel struct {
+	TenantID uint `gorm:"primaryKey;autoIncrement:false"`
+	ID       uint `gorm:"primaryKey;autoIncrement:false"`
+	optimistic.Versioned
+	DeletedAt gorm.DeletedAt
+
+	Value int
+}
+
+// expandRowValueIN rewrites conditions like `(a, b) IN ((1, 2))`, which GORM generates for composite primary keys but
+// sqlite does not support, into the equivalent `(a = 1 AND b = 2)`
+func expandRowValueIN(c clause.Clause, builder clause.Builder) {
+	if where, ok := c.Expression.(clause.Where); ok {
+		exprs := make([]clause.Expression, len(where.Exprs))
+		for idx, expr := range where.Exprs {
+			exprs[idx] = expr
+			if in, ok := expr.(clause.IN); ok {
+				if columns, ok := in.Column.([]clause.Column); ok {
+					rows := make([]clause.Expression, len(in.Values))
+					for rowIdx, row := range in.Values {
+						conditions := make([]clause.Expression, len(columns))
+						for columnIdx, column := range columns {
+							conditions[columnIdx] = clause.Eq{Column: column, Value: row.([]interface{})[columnIdx]}
+						}
+						rows[rowIdx] = clause.And(conditions...)
+					}
+					exprs[idx] = clause.Or(rows...)
+				}
+			}
+		}
+		c.Expression = clause.Where{Exprs: exprs}
+	}
+	c.Build(builder)
+}
+
+var _ = Describe("Composite primary keys", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		db.ClauseBuilders["WHERE"] = expandRowValueIN
+		Expect(db.AutoMigrate(&CompositeModel{})).To(Succeed())
+
+		// two rows sharing an ID and version, distinguished only by tenant - one of which is the zero value
+		Expect(db.Create(&CompositeModel{TenantID: 0, ID: TestID}).Error).To(Succeed())
+		Expect(db.Create(&CompositeModel{TenantID: 1, ID: TestID}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	versionOf := func(tenantID uint) uint64 {
+		m := &CompositeModel{}
+		Expect(db.Unscoped().Where("tenant_id = ? AND id = ?", tenantID, TestID).First(m).Error).To(Succeed())
+		return m.Version
+	}
+
+	It("only bumps the version of the soft deleted row", func() {
+		m := &CompositeModel{}
+		Expect(db.Where("tenant_id = ? AND id = ?", 0, TestID).First(m).Error).To(Succeed())
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		Expect(versionOf(0)).To(BeNumerically("==", 2))
+		Expect(versionOf(1)).To(BeNumerically("==", 1))
+	})
+
+	It("only updates the intended row", func() {
+		m := &CompositeModel{}
+		Expect(db.Where("tenant_id = ? AND id = ?", 1, TestID).First(m).Error).To(Succeed())
+		m.Value = 100
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(versionOf(0)).To(BeNumerically("==", 1))
+		Expect(versionOf(1)).To(BeNumerically("==", 2))
+	})
+})
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Updates with conditions", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	makeStale := func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 110
+		Expect(db.Updates(other).Error).To(Succeed())
+	}
+
+	It("applies when both the version and the user's condition match", func() {
+		Expect(db.Model(m).Where("value > ?", 10).Update("value", 200).Error).To(Succeed())
+		Expect(persisted().Value).To(Equal(200))
+	})
+
+	It("does not apply when the user's condition does not match", func() {
+		Expect(db.Model(m).Where("value < ?", 10).Update("value", 200).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(100))
+	})
+
+	It("does not apply when the version does not match", func() {
+		makeStale()
+
+		Expect(db.Model(m).Where("value > ?", 10).Update("value", 200).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(110))
+	})
+
+	It("guards every alternative of a user's OR condition", func() {
+		makeStale()
+
+		Expect(db.Model(m).Where("value = ?", 110).Or("value = ?", 100).Update("value", 200).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Model(m).Where("value = ? OR value = ?", 110, 100).Update("value", 200).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(110))
+	})
+
+	for _, usePlugin := range []bool{false, true} {
+		usePlugin := usePlugin
+		name := "updating with a map"
+		if usePlugin {
+			name += " with the plugin installed"
+		}
+
+		When(name, func() {
+			JustBeforeEach(func() {
+				if usePlugin {
+					Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+				}
+			})
+
+			It("guards the update and moves the version on along with the user's condition", func() {
+				values := map[string]interface{}{"value": 200}
+				Expect(db.Model(m).Where("value > ?", 10).Updates(values).Error).To(Succeed())
+				Expect(values).To(Equal(map[string]interface{}{"value": 200}))
+				Expect(m.Version).To(BeNumerically("==", 2))
+
+				p := persisted()
+				Expect(p.Value).To(Equal(200))
+				Expect(p.Version).To(BeNumerically("==", 2))
+			})
+
+			It("does not apply when the version does not match", func() {
+				makeStale()
+
+				Expect(db.Model(m).Where("value > ?", 10).Updates(map[string]interface{}{"value": 200}).Error).
+					To(MatchError(optimistic.ErrConcurrentModification))
+				p := persisted()
+				Expect(p.Value).To(Equal(110))
+				Expect(p.Version).To(BeNumerically("==", 2))
+			})
+
+			It("does not apply when the user's condition does not match", func() {
+				Expect(db.Model(m).Where("value < ?", 10).Updates(map[string]interface{}{"value": 200}).Error).
+					To(MatchError(optimistic.ErrConcurrentModification))
+				Expect(persisted().Version).To(BeNumerically("==", 1))
+			})
+		})
+	}
+
+	It("guards every alternative of a user's OR condition when deleting", func() {
+		makeStale()
+
+		Expect(db.Where("value = ?", 110).Or("value = ?", 100).Delete(m).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().DeletedAt.Valid).To(BeFalse())
+	})
+})
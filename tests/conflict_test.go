@@ -0,0 +1,362 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type conflictContextKey struct{}
+
+var _ = Describe("OnConflict", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var unregister []func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		for _, fn := range unregister {
+			fn()
+		}
+		unregister = nil
+
+		closeDB()
+	})
+
+	conflict := func(ctx context.Context) error {
+		a := &TestModel{}
+		b := &TestModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		Expect(db.First(b, TestID).Error).To(Succeed())
+
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+
+		b.Value = 300
+		return db.WithContext(ctx).Updates(b).Error
+	}
+
+	It("calls the callback with details of the conflict", func() {
+		var received []optimistic.ConflictInfo
+		var receivedValues []interface{}
+		unregister = append(unregister, optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+			received = append(received, info)
+			receivedValues = append(receivedValues, ctx.Value(conflictContextKey{}))
+		}))
+
+		ctx := context.WithValue(context.Background(), conflictContextKey{}, "marker")
+		Expect(conflict(ctx)).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].Table).To(Equal("test_models"))
+		Expect(received[0].PrimaryKey).To(BeEquivalentTo(TestID))
+		Expect(received[0].ExpectedVersion).To(BeNumerically("==", 1))
+		Expect(receivedValues).To(Equal([]interface{}{"marker"}))
+	})
+
+	It("calls multiple callbacks in registration order", func() {
+		var order []string
+		unregister = append(unregister,
+			optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+				order = append(order, "first")
+			}),
+			optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+				order = append(order, "second")
+			}),
+		)
+
+		Expect(conflict(context.Background())).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(order).To(Equal([]string{"first", "second"}))
+	})
+
+	It("does not call callbacks when there is no conflict", func() {
+		called := false
+		unregister = append(unregister, optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+			called = true
+		}))
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(called).To(BeFalse())
+	})
+
+	It("stops calling a callback once unregistered", func() {
+		called := false
+		optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+			called = true
+		})()
+
+		Expect(conflict(context.Background())).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(called).To(BeFalse())
+	})
+})
+
+var _ = Describe("ConflictFromContext", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var a, b *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		a = &TestModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		b = &TestModel{}
+		Expect(db.First(b, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("reads back a conflict detected within a transaction using the context", func() {
+		ctx := optimistic.WithConflictTracking(context.Background())
+		_, ok := optimistic.ConflictFromContext(ctx)
+		Expect(ok).To(BeFalse())
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			a.Value = 200
+			if err := tx.Updates(a).Error; err != nil {
+				return err
+			}
+			b.Value = 300
+			return tx.Updates(b).Error
+		})
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+		info, ok := optimistic.ConflictFromContext(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(info.Operation).To(Equal(optimistic.OperationUpdate))
+		Expect(info.Table).To(Equal("test_models"))
+		Expect(info.PrimaryKey).To(BeEquivalentTo(TestID))
+		Expect(info.ExpectedVersion).To(BeNumerically("==", 1))
+	})
+
+	It("is visible through contexts derived from the tracking context", func() {
+		ctx := optimistic.WithConflictTracking(context.Background())
+		derived, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+		b.Value = 300
+		Expect(db.WithContext(derived).Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		_, ok := optimistic.ConflictFromContext(ctx)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("reports nothing for contexts that do not track conflicts", func() {
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+		b.Value = 300
+		Expect(db.WithContext(context.Background()).Updates(b).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+
+		_, ok := optimistic.ConflictFromContext(context.Background())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("reports nothing if no write conflicted", func() {
+		ctx := optimistic.WithConflictTracking(context.Background())
+		a.Value = 200
+		Expect(db.WithContext(ctx).Updates(a).Error).To(Succeed())
+
+		_, ok := optimistic.ConflictFromContext(ctx)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var errEscalated = errors.New("escalated conflict")
+
+// VetoingModel decides what to do about conflicting writes based on its priority
+type VetoingModel struct {
+	ID uint
+	optimistic.Versioned
+
+	Value    int
+	Priority string
+}
+
+func (m *VetoingModel) OnConflictDetected(ctx context.Context) (bool, error) {
+	switch m.Priority {
+	case "low":
+		// low priority writes can be dropped if they lose a race
+		return true, nil
+	case "high":
+		return false, errEscalated
+	default:
+		return false, nil
+	}
+}
+
+var _ = Describe("ConflictVetoer", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *VetoingModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&VetoingModel{})).To(Succeed())
+
+		Expect(db.Create(&VetoingModel{ID: TestID, Value: 100}).Error).To(Succeed())
+
+		stale = &VetoingModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		m := &VetoingModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectUnchanged := func() {
+		p := &VetoingModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	}
+
+	It("lets the model allow a conflicting write to succeed without applying it", func() {
+		stale.Priority = "low"
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(Succeed())
+		expectUnchanged()
+	})
+
+	It("lets the model replace the conflict with its own error", func() {
+		stale.Priority = "high"
+		stale.Value = 300
+		err := db.Updates(stale).Error
+		Expect(err).To(MatchError(errEscalated))
+		Expect(optimistic.IsConflict(err)).To(BeFalse())
+		expectUnchanged()
+	})
+
+	It("keeps the conflict otherwise", func() {
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		expectUnchanged()
+	})
+
+	It("still reports vetoed conflicts to callbacks", func() {
+		var received []optimistic.ConflictInfo
+		defer optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+			received = append(received, info)
+		})()
+
+		stale.Priority = "low"
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(Succeed())
+		Expect(received).To(HaveLen(1))
+	})
+})
+
+// StaleTestModelError is the error conflicting writes to TestModels are made to fail with
+type StaleTestModelError struct {
+	ID interface{}
+}
+
+func (e *StaleTestModelError) Error() string {
+	return fmt.Sprintf("test model %v was changed by someone else, reload it and try again", e.ID)
+}
+
+var _ = Describe("SetConflictErrorFactory", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &VetoingModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		optimistic.SetConflictErrorFactory("test_models", func(info optimistic.ConflictInfo) error {
+			return &StaleTestModelError{ID: info.PrimaryKey}
+		})
+	})
+
+	JustAfterEach(func() {
+		optimistic.SetConflictErrorFactory("test_models", nil)
+		closeDB()
+	})
+
+	It("fails conflicting writes to the table with the custom error", func() {
+		stale.Value = 300
+		err := db.Updates(stale).Error
+		Expect(err).To(MatchError("test model 1 was changed by someone else, reload it and try again"))
+
+		var custom *StaleTestModelError
+		Expect(errors.As(err, &custom)).To(BeTrue())
+		Expect(custom.ID).To(BeEquivalentTo(TestID))
+	})
+
+	It("still reports the error as a conflict", func() {
+		err := db.Delete(stale).Error
+		Expect(optimistic.IsConflict(err)).To(BeTrue())
+
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.Operation).To(Equal(optimistic.OperationSoftDelete))
+		Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+	})
+
+	It("keeps the custom error through Transaction", func() {
+		err := optimistic.Transaction(db, func(tx *gorm.DB) error {
+			stale.Value = 300
+			return tx.Updates(stale).Error
+		})
+		var custom *StaleTestModelError
+		Expect(errors.As(err, &custom)).To(BeTrue())
+	})
+
+	It("leaves conflicts on other tables alone", func() {
+		Expect(db.Create(&VetoingModel{ID: TestID, Value: 100}).Error).To(Succeed())
+		a := &VetoingModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		b := &VetoingModel{}
+		Expect(db.First(b, TestID).Error).To(Succeed())
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+
+		b.Value = 300
+		Expect(db.Updates(b).Error).To(BeAssignableToTypeOf(&optimistic.ConflictError{}))
+	})
+
+	It("restores the default once the factory is removed", func() {
+		optimistic.SetConflictErrorFactory("test_models", nil)
+		stale.Value = 300
+		err := db.Updates(stale).Error
+		Expect(err).To(BeAssignableToTypeOf(&optimistic.ConflictError{}))
+	})
+})
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("High contention", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var contended []optimistic.HighContentionInfo
+	var stale *TestModel
+
+	BeforeEach(func() {
+		contended = nil
+
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{
+			HighContentionThreshold: 3,
+			OnHighContention: func(ctx context.Context, info optimistic.HighContentionInfo) {
+				contended = append(contended, info)
+			},
+		}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	AfterEach(func() {
+		closeDB()
+	})
+
+	writeConcurrently := func(times int) {
+		for i := 0; i < times; i++ {
+			m := &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			m.Value++
+			Expect(db.Updates(m).Error).To(Succeed())
+		}
+	}
+
+	It("calls the callback when the row has moved on by more than the threshold", func() {
+		writeConcurrently(4)
+
+		stale.Value = 200
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(contended).To(HaveLen(1))
+		Expect(contended[0].Table).To(Equal("test_models"))
+		Expect(contended[0].ExpectedVersion).To(BeEquivalentTo(1))
+		Expect(contended[0].CurrentVersion).To(BeEquivalentTo(5))
+		Expect(contended[0].Gap).To(BeEquivalentTo(4))
+	})
+
+	It("checks deletes too", func() {
+		writeConcurrently(4)
+
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(contended).To(HaveLen(1))
+		Expect(contended[0].Operation).To(Equal(optimistic.OperationSoftDelete))
+	})
+
+	It("does not call the callback when the row has moved on by no more than the threshold", func() {
+		writeConcurrently(3)
+
+		stale.Value = 200
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(contended).To(BeEmpty())
+	})
+
+	It("does not call the callback for writes that do not conflict", func() {
+		stale.Value = 200
+		Expect(db.Updates(stale).Error).To(Succeed())
+		Expect(contended).To(BeEmpty())
+	})
+})
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var errProtected = errors.New("model is protected")
+
+// ProtectedModel refuses to be deleted while it is protected, or once it has been modified since it was created
+type ProtectedModel struct {
+	gorm.Model
+	optimistic.Versioned
+	Protected bool
+}
+
+func (m *ProtectedModel) ValidateDelete(tx *gorm.DB) error {
+	if m.Protected || m.ReadVersion() > 1 {
+		return errProtected
+	}
+	return nil
+}
+
+var _ = Describe("PreDeleteValidator", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *ProtectedModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&ProtectedModel{})).To(Succeed())
+		Expect(db.Create(&ProtectedModel{Model: gorm.Model{ID: TestID}, Protected: true}).Error).To(Succeed())
+
+		m = &ProtectedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectRemains := func() {
+		stored := &ProtectedModel{}
+		Expect(db.First(stored, TestID).Error).To(Succeed())
+		Expect(stored.Version).To(BeNumerically("==", m.ReadVersion()))
+		Expect(m.Version).To(Equal(m.ReadVersion()))
+	}
+
+	It("refuses to soft delete the model", func() {
+		Expect(db.Delete(m).Error).To(MatchError(errProtected))
+		expectRemains()
+	})
+
+	It("refuses to hard delete the model", func() {
+		Expect(db.Unscoped().Delete(m).Error).To(MatchError(errProtected))
+		expectRemains()
+	})
+
+	It("refuses based on the version the model was read at", func() {
+		m.Protected = false
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(db.First(m, TestID).Error).To(Succeed())
+
+		Expect(db.Delete(m).Error).To(MatchError(errProtected))
+		expectRemains()
+	})
+
+	It("deletes the model when it does not refuse", func() {
+		Expect(db.Create(&ProtectedModel{Model: gorm.Model{ID: TestID + 1}}).Error).To(Succeed())
+		unprotected := &ProtectedModel{}
+		Expect(db.First(unprotected, TestID+1).Error).To(Succeed())
+
+		Expect(db.Delete(unprotected).Error).To(Succeed())
+		Expect(db.First(&ProtectedModel{}, TestID+1).Error).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	When("using the plugin", func() {
+		JustBeforeEach(func() {
+			Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		})
+
+		It("refuses to delete the model", func() {
+			Expect(db.Delete(m).Error).To(MatchError(errProtected))
+			expectRemains()
+		})
+	})
+})
+
+var _ = Describe("Idempotent deletes", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var opts optimistic.PluginOptions
+	var a, b *TestModel
+
+	BeforeEach(func() {
+		opts = optimistic.PluginOptions{IdempotentDelete: true}
+	})
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(opts))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		a = &TestModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		b = &TestModel{}
+		Expect(db.First(b, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("succeeds deleting a model that has already been soft deleted", func() {
+		Expect(db.Delete(a).Error).To(Succeed())
+		Expect(db.Delete(b).Error).To(Succeed())
+	})
+
+	It("succeeds deleting a model that has already been hard deleted", func() {
+		Expect(db.Unscoped().Delete(a).Error).To(Succeed())
+		Expect(db.Delete(b).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(b).Error).To(Succeed())
+	})
+
+	It("leaves the deleted row as it was", func() {
+		Expect(db.Delete(a).Error).To(Succeed())
+		Expect(db.Delete(b).Error).To(Succeed())
+
+		deleted := &TestModel{}
+		Expect(db.Unscoped().First(deleted, TestID).Error).To(Succeed())
+		Expect(deleted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("still conflicts deleting a model that has been modified", func() {
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+		Expect(db.Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Unscoped().Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("still reports updates of deleted models", func() {
+		Expect(db.Delete(a).Error).To(Succeed())
+		b.Value = 200
+		Expect(db.Updates(b).Error).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	When("the option is not set", func() {
+		BeforeEach(func() {
+			opts.IdempotentDelete = false
+		})
+
+		It("reports deletes of models that have already been deleted", func() {
+			Expect(db.Delete(a).Error).To(Succeed())
+			err := db.Delete(b).Error
+			Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+			Expect(optimistic.IsConflict(err)).To(BeFalse())
+		})
+	})
+})
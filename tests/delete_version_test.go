@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("WithDeleteVersion", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeDeleteVersion := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{}, &RevisionedModel{})).To(Succeed())
+
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func() *TestModel {
+			p := &TestModel{}
+			Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("soft deletes at the given version", func() {
+			Expect(optimistic.WithDeleteVersion(db, 42).Delete(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 42))
+
+			p := persisted()
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 42))
+		})
+
+		It("soft deletes slices at the given version", func() {
+			Expect(optimistic.WithDeleteVersion(db, 42).Delete(&[]*TestModel{m}).Error).To(Succeed())
+
+			p := persisted()
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 42))
+		})
+
+		It("still guards on the version the model was read at", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 200
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			err := optimistic.WithDeleteVersion(db, 42).Delete(m).Error
+			Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+			p := persisted()
+			Expect(p.DeletedAt.Valid).To(BeFalse())
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		if usePlugin {
+			// tagged version fields are only locked by the plugin
+			It("restores tagged version fields to the version they were read at on conflict", func() {
+				Expect(db.Create(&RevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+				Expect(db.Updates(&RevisionedModel{ID: TestID, Value: 200}).Error).To(Succeed())
+
+				stale := &RevisionedModel{}
+				Expect(db.First(stale, TestID).Error).To(Succeed())
+				Expect(stale.Rev).To(BeNumerically("==", 1))
+
+				other := &RevisionedModel{}
+				Expect(db.First(other, TestID).Error).To(Succeed())
+				other.Value = 300
+				Expect(db.Updates(other).Error).To(Succeed())
+
+				err := optimistic.WithDeleteVersion(db, 10).Delete(stale).Error
+				Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+				Expect(stale.Rev).To(BeNumerically("==", 1))
+
+				var conflict *optimistic.ConflictError
+				Expect(errors.As(err, &conflict)).To(BeTrue())
+				Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+
+				p := &RevisionedModel{}
+				Expect(db.First(p, TestID).Error).To(Succeed())
+				Expect(p.Rev).To(BeNumerically("==", 2))
+			})
+		}
+
+		It("rejects the version the model was read at", func() {
+			err := optimistic.WithDeleteVersion(db, 1).Delete(m).Error
+			Expect(err).To(MatchError(optimistic.ErrVersionUnchanged))
+			Expect(m.Version).To(BeNumerically("==", 1))
+			Expect(persisted().DeletedAt.Valid).To(BeFalse())
+		})
+
+		It("does not affect updates", func() {
+			m.Value = 200
+			Expect(optimistic.WithDeleteVersion(db, 42).Updates(m).Error).To(Succeed())
+			Expect(persisted().Version).To(BeNumerically("==", 2))
+		})
+	}
+
+	describeDeleteVersion(false)
+
+	When("using the plugin", func() {
+		describeDeleteVersion(true)
+	})
+})
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Diff", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var local *TestModel
+
+	BeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		local = &TestModel{}
+		Expect(db.First(local, TestID).Error).To(Succeed())
+	})
+
+	AfterEach(func() {
+		closeDB()
+	})
+
+	It("reports nothing for a model matching its row", func() {
+		Expect(optimistic.Diff(db, local)).To(BeEmpty())
+	})
+
+	It("reports a field modified in the database", func() {
+		Expect(db.Model(&TestModel{}).Where("id = ?", TestID).UpdateColumn("value", 200).Error).To(Succeed())
+
+		Expect(optimistic.Diff(db, local)).To(Equal(map[string]optimistic.FieldDiff{
+			"Value": {Local: 100, Remote: 200},
+		}))
+		Expect(local.Value).To(Equal(100))
+	})
+
+	It("reports how far the version has moved on since the model was read", func() {
+		remote := &TestModel{}
+		Expect(db.First(remote, TestID).Error).To(Succeed())
+		remote.Value = 200
+		Expect(db.Updates(remote).Error).To(Succeed())
+
+		// a conflicting write leaves the model holding the version it would have written
+		local.Value = 300
+		Expect(db.Updates(local).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		diffs, err := optimistic.Diff(db, local)
+		Expect(err).To(Succeed())
+		Expect(diffs).To(HaveKeyWithValue("Value", optimistic.FieldDiff{Local: 300, Remote: 200}))
+		Expect(diffs).To(HaveKeyWithValue("Version", optimistic.FieldDiff{Local: uint64(1), Remote: uint64(2)}))
+	})
+
+	It("returns ErrRecordNotFound if the row no longer exists", func() {
+		Expect(db.Exec("DELETE FROM test_models WHERE id = ?", TestID).Error).To(Succeed())
+
+		_, err := optimistic.Diff(db, local)
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+	})
+})
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("DryRun", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var fresh, stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+
+		fresh = &TestModel{}
+		Expect(db.First(fresh, TestID).Error).To(Succeed())
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID+1).Error).To(Succeed())
+
+		other := &TestModel{}
+		Expect(db.First(other, TestID+1).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func(id uint) *TestModel {
+		p := &TestModel{}
+		Expect(db.Unscoped().First(p, id).Error).To(Succeed())
+		return p
+	}
+
+	It("reports stale updates as conflicting without writing anything", func() {
+		tx, report := optimistic.DryRun(db)
+
+		fresh.Value = 300
+		Expect(tx.Updates(fresh).Error).To(Succeed())
+		stale.Value = 300
+		Expect(tx.Updates(stale).Error).To(Succeed())
+
+		results := report.Results()
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].PrimaryKey).To(BeEquivalentTo(TestID))
+		Expect(results[0].Conflict).To(BeNil())
+		Expect(results[1].PrimaryKey).To(BeEquivalentTo(TestID + 1))
+		Expect(results[1].Conflict).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(results[1].Conflict.ExpectedVersion).To(BeNumerically("==", 1))
+		Expect(report.Conflicts()).To(Equal([]*optimistic.ConflictError{results[1].Conflict}))
+
+		// neither the rows nor the models are changed
+		Expect(persisted(TestID).Value).To(Equal(100))
+		Expect(persisted(TestID).Version).To(BeNumerically("==", 1))
+		Expect(persisted(TestID + 1).Value).To(Equal(200))
+		Expect(persisted(TestID + 1).Version).To(BeNumerically("==", 2))
+		Expect(fresh.Version).To(BeNumerically("==", 1))
+		Expect(stale.Version).To(BeNumerically("==", 1))
+
+		// so the checked writes can still be made for real
+		Expect(db.Updates(fresh).Error).To(Succeed())
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("checks deletes", func() {
+		tx, report := optimistic.DryRun(db)
+
+		Expect(tx.Delete(fresh).Error).To(Succeed())
+		Expect(tx.Unscoped().Delete(stale).Error).To(Succeed())
+
+		results := report.Results()
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Operation).To(Equal(optimistic.OperationSoftDelete))
+		Expect(results[0].Conflict).To(BeNil())
+		Expect(results[1].Operation).To(Equal(optimistic.OperationHardDelete))
+		Expect(results[1].Conflict).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(persisted(TestID).DeletedAt.Valid).To(BeFalse())
+		Expect(persisted(TestID).Version).To(BeNumerically("==", 1))
+		Expect(persisted(TestID + 1).Version).To(BeNumerically("==", 2))
+	})
+
+	It("reports writes to rows that no longer exist", func() {
+		Expect(db.Unscoped().Delete(fresh).Error).To(Succeed())
+
+		tx, report := optimistic.DryRun(db)
+		fresh.Value = 300
+		Expect(tx.Updates(fresh).Error).To(Succeed())
+
+		results := report.Results()
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Conflict).To(BeNil())
+		Expect(results[0].Err).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("checks writes of models in a slice", func() {
+		var models []TestModel
+		Expect(db.Order("id").Find(&models).Error).To(Succeed())
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		tx, report := optimistic.DryRun(db)
+		Expect(tx.Delete(&models).Error).To(Succeed())
+
+		results := report.Results()
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Conflict).NotTo(BeNil())
+		Expect(results[1].Conflict).To(BeNil())
+	})
+})
+
+var _ = Describe("GORM's DryRun sessions", func() {
+	var db, dryRun *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeDryRun := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+
+			dryRun = db.Session(&gorm.Session{DryRun: true})
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		expectUnwritten := func() {
+			p := &TestModel{}
+			Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+			Expect(p.Value).To(Equal(100))
+			Expect(p.Version).To(BeNumerically("==", 1))
+			Expect(p.DeletedAt.Valid).To(BeFalse())
+		}
+
+		It("builds the guarded SQL of an update", func() {
+			m.Value = 200
+			result := dryRun.Updates(m)
+			Expect(result.Error).To(Succeed())
+
+			sql := result.Statement.SQL.String()
+			Expect(sql).To(HavePrefix("UPDATE"))
+			Expect(sql).To(ContainSubstring("`version`=?"))
+			Expect(sql).To(ContainSubstring("`version` = ?"))
+			Expect(result.Statement.Vars).To(ContainElement(uint64(2)))
+			Expect(result.Statement.Vars).To(ContainElement(uint64(1)))
+			expectUnwritten()
+		})
+
+		It("builds the guarded SQL of a soft delete", func() {
+			result := dryRun.Delete(m)
+			Expect(result.Error).To(Succeed())
+
+			sql := result.Statement.SQL.String()
+			Expect(sql).To(HavePrefix("UPDATE"))
+			Expect(sql).To(ContainSubstring("`version`=?"))
+			Expect(sql).To(ContainSubstring("`version` = ?"))
+			expectUnwritten()
+		})
+
+		It("does not check the affected rows of updates made without hooks", func() {
+			Expect(optimistic.UpdateColumnVersioned(dryRun.Model(m), "value", 200)).To(Succeed())
+			Expect(optimistic.Exec(dryRun, m, "value = ?", 200)).To(Succeed())
+			expectUnwritten()
+		})
+	}
+
+	describeDryRun(false)
+
+	When("using the plugin", func() {
+		describeDryRun(true)
+	})
+})
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Errors", func() {
+	Describe("IsConflict", func() {
+		It("detects the bare sentinel", func() {
+			Expect(optimistic.IsConflict(optimistic.ErrConcurrentModification)).To(BeTrue())
+		})
+
+		It("detects a wrapped ConflictError", func() {
+			err := fmt.Errorf("saving model: %w", &optimistic.ConflictError{
+				Table:           "test_models",
+				PrimaryKey:      TestID,
+				ExpectedVersion: 1,
+			})
+			Expect(optimistic.IsConflict(err)).To(BeTrue())
+		})
+
+		It("does not detect other errors", func() {
+			Expect(optimistic.IsConflict(errors.New("some other failure"))).To(BeFalse())
+		})
+
+		It("does not detect nil", func() {
+			Expect(optimistic.IsConflict(nil)).To(BeFalse())
+		})
+	})
+
+	Describe("ConflictError", func() {
+		var db *gorm.DB
+		var closeDB func()
+
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			Expect(db.AutoMigrate(&UndeletableModel{})).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		It("reports deletes of models that cannot be soft deleted as hard deletes", func() {
+			Expect(db.Create(&UndeletableModel{ID: TestID}).Error).To(Succeed())
+			stale := &UndeletableModel{}
+			Expect(db.First(stale, TestID).Error).To(Succeed())
+
+			m := &UndeletableModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			Expect(db.Model(m).Update("version", 2).Error).To(Succeed())
+
+			var conflict *optimistic.ConflictError
+			Expect(errors.As(db.Delete(stale).Error, &conflict)).To(BeTrue())
+			Expect(conflict.Operation).To(Equal(optimistic.OperationHardDelete))
+			Expect(conflict.Info().Operation).To(Equal(optimistic.OperationHardDelete))
+		})
+
+		It("still matches the sentinel for every operation", func() {
+			for _, op := range []optimistic.Operation{
+				optimistic.OperationUpdate, optimistic.OperationSoftDelete, optimistic.OperationHardDelete,
+			} {
+				err := &optimistic.ConflictError{Operation: op, Table: "test_models"}
+				Expect(errors.Is(err, optimistic.ErrConcurrentModification)).To(BeTrue())
+			}
+		})
+
+		It("has the status code of a conflict", func() {
+			Expect(db.Create(&UndeletableModel{ID: TestID}).Error).To(Succeed())
+			stale := &UndeletableModel{}
+			Expect(db.First(stale, TestID).Error).To(Succeed())
+			m := &UndeletableModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			Expect(db.Model(m).Update("version", 2).Error).To(Succeed())
+
+			err := db.Delete(stale).Error
+			Expect(errors.Is(err, optimistic.ErrConcurrentModification)).To(BeTrue())
+
+			var coded interface{ StatusCode() int }
+			Expect(errors.As(err, &coded)).To(BeTrue())
+			Expect(coded.StatusCode()).To(Equal(http.StatusConflict))
+		})
+
+		It("marshals to JSON", func() {
+			data, err := json.Marshal(&optimistic.ConflictError{
+				Operation:       optimistic.OperationSoftDelete,
+				Table:           "test_models",
+				PrimaryKey:      TestID,
+				ExpectedVersion: 3,
+			})
+			Expect(err).To(Succeed())
+			Expect(data).To(MatchJSON(`{"error":"conflict","table":"test_models","pk":1,"expected_version":3,` +
+				`"operation":"soft delete"}`))
+		})
+
+		It("marshals conflicts held as errors, with composite primary keys, to JSON", func() {
+			data, err := json.Marshal(struct {
+				Err error `json:"err"`
+			}{Err: &optimistic.ConflictError{Table: "test_models", PrimaryKey: []interface{}{1, "a"}}})
+			Expect(err).To(Succeed())
+			Expect(data).To(MatchJSON(`{"err":{"error":"conflict","table":"test_models","pk":[1,"a"],` +
+				`"expected_version":0,"operation":"update"}}`))
+		})
+
+		It("leaves out fields that are not set when marshaling to JSON", func() {
+			data, err := json.Marshal(&optimistic.ConflictError{ExpectedToken: "abc"})
+			Expect(err).To(Succeed())
+			Expect(data).To(MatchJSON(`{"error":"conflict","expected_token":"abc","operation":"update"}`))
+		})
+
+		It("names each operation", func() {
+			Expect(optimistic.OperationUpdate.String()).To(Equal("update"))
+			Expect(optimistic.OperationSoftDelete.String()).To(Equal("soft delete"))
+			Expect(optimistic.OperationHardDelete.String()).To(Equal("hard delete"))
+		})
+	})
+})
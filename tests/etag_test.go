@@ -0,0 +1,91 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type EtagModel struct {
+	gorm.Model
+	optimistic.Etagged
+
+	Value int
+}
+
+var _ = Describe("Etagged", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *EtagModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&EtagModel{})).To(Succeed())
+
+		m = &EtagModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(m).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("creates the entry with a random UUID", func() {
+		Expect(m.CurrentEtag()).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(m.ReadEtag()).To(Equal(m.CurrentEtag()))
+
+		persisted := &EtagModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.ReadEtag()).To(Equal(m.CurrentEtag()))
+	})
+
+	It("succeeds updating with a fresh ETag, persisting a new one", func() {
+		fresh := &EtagModel{}
+		Expect(db.First(fresh, TestID).Error).To(Succeed())
+		read := fresh.ReadEtag()
+
+		fresh.Value = 200
+		Expect(db.Updates(fresh).Error).To(Succeed())
+		Expect(fresh.CurrentEtag()).NotTo(Equal(read))
+		Expect(fresh.ReadEtag()).To(Equal(read))
+
+		persisted := &EtagModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Etag).To(Equal(fresh.CurrentEtag()))
+	})
+
+	It("fails updating with a stale ETag", func() {
+		stale := &EtagModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		fresh := &EtagModel{}
+		Expect(db.First(fresh, TestID).Error).To(Succeed())
+		fresh.Value = 200
+		Expect(db.Updates(fresh).Error).To(Succeed())
+
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("persists a new ETag on soft deletion", func() {
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		persisted := &EtagModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Etag).To(Equal(m.CurrentEtag()))
+		Expect(persisted.Etag).NotTo(Equal(m.ReadEtag()))
+	})
+
+	It("fails deleting with a stale ETag", func() {
+		stale := &EtagModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Unscoped().Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+})
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Exec", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m, stale *TestModel
+
+	BeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &HashedModel{}, &UnlockedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	AfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("applies the raw update and increments the version", func() {
+		Expect(optimistic.Exec(db, m, "value = value + ?", 5)).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		p := persisted()
+		Expect(p.Value).To(Equal(105))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	})
+
+	It("detects concurrent modification", func() {
+		Expect(optimistic.Exec(db, m, "value = value + ?", 5)).To(Succeed())
+
+		err := optimistic.Exec(db, stale, "value = value + ?", 5)
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+		p := persisted()
+		Expect(p.Value).To(Equal(105))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	})
+
+	It("reports missing rows", func() {
+		Expect(db.Exec("DELETE FROM test_models WHERE id = ?", TestID).Error).To(Succeed())
+
+		err := optimistic.Exec(db, m, "value = ?", 200)
+		Expect(err).To(BeAssignableToTypeOf(&optimistic.MissingRowError{}))
+	})
+
+	It("rejects models that were never read", func() {
+		err := optimistic.Exec(db, &TestModel{Model: gorm.Model{ID: TestID}}, "value = ?", 200)
+		Expect(err).To(MatchError(optimistic.ErrUnknownReadVersion))
+	})
+
+	It("rejects models locked by their content", func() {
+		Expect(optimistic.Exec(db, &HashedModel{}, "value = ?", 200)).To(MatchError(optimistic.ErrContentLocked))
+	})
+
+	It("rejects models that are not locked", func() {
+		Expect(optimistic.Exec(db, &UnlockedModel{}, "value = ?", 200)).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("WithExpectedVersion", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var detached *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &HashedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		// a model decoded from a request, along with the version its client was shown
+		detached = &TestModel{Model: gorm.Model{ID: TestID}, Value: 300}
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("updates a model at the expected version", func() {
+		Expect(optimistic.WithExpectedVersion(db, 2).Updates(detached).Error).To(Succeed())
+		Expect(detached.Version).To(BeNumerically("==", 3))
+		Expect(detached.ReadVersion()).To(BeNumerically("==", 2))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("rejects an update at any other version", func() {
+		err := optimistic.WithExpectedVersion(db, 1).Updates(detached).Error
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("overrides the version the model was read at", func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 300
+
+		Expect(optimistic.WithExpectedVersion(db, 1).Updates(m).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("deletes a model at the expected version", func() {
+		Expect(optimistic.WithExpectedVersion(db, 1).Delete(detached).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(optimistic.WithExpectedVersion(db, 2).Delete(detached).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.DeletedAt.Valid).To(BeTrue())
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("requires a model locked by a version number", func() {
+		Expect(db.Create(&HashedModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		err := optimistic.WithExpectedVersion(db, 1).Updates(&HashedModel{Model: gorm.Model{ID: TestID}, Value: 200}).Error
+		Expect(err).To(MatchError(optimistic.ErrNoVersionNumber))
+	})
+})
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("ExplicitVersion", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("writes the version set on the model", func() {
+		m.Value = 200
+		m.Version = 42
+		Expect(db.Scopes(optimistic.ExplicitVersion).Updates(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 42))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 42))
+	})
+
+	It("still rejects an update to a stale model", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 300
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		m.Value = 200
+		m.Version = 42
+		Expect(db.Scopes(optimistic.ExplicitVersion).Updates(m).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("writes the version set on the model when soft deleting", func() {
+		m.Version = 42
+		Expect(db.Scopes(optimistic.ExplicitVersion).Delete(m).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.DeletedAt.Valid).To(BeTrue())
+		Expect(persisted.Version).To(BeNumerically("==", 42))
+	})
+
+	It("rejects an update that leaves the version unchanged", func() {
+		m.Value = 200
+		Expect(db.Scopes(optimistic.ExplicitVersion).Updates(m).Error).To(MatchError(optimistic.ErrVersionUnchanged))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(100))
+	})
+
+	It("only affects the scoped statement", func() {
+		m.Version = 42
+		Expect(db.Scopes(optimistic.ExplicitVersion).Updates(m).Error).To(Succeed())
+
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 43))
+	})
+})
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"errors"
+	"math"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// RevisionedModel uses a field of its own as its version, rather than embedding optimistic.Versioned
+type RevisionedModel struct {
+	ID        uint
+	Value     int
+	Rev       uint64 `gorm:"optimisticlock"`
+	DeletedAt gorm.DeletedAt
+}
+
+var _ = Describe("Tagged version fields", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m, stale *RevisionedModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		Expect(db.AutoMigrate(&RevisionedModel{})).To(Succeed())
+
+		Expect(db.Create(&RevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+
+		m = &RevisionedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &RevisionedModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *RevisionedModel {
+		p := &RevisionedModel{}
+		Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("increments the field on every update", func() {
+		for rev := uint64(1); rev <= 3; rev++ {
+			m.Value += 1
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.Rev).To(Equal(rev))
+			Expect(persisted().Rev).To(Equal(rev))
+		}
+	})
+
+	It("increments the field when other columns are selected", func() {
+		m.Value = 200
+		Expect(db.Select("Value").Updates(m).Error).To(Succeed())
+		Expect(m.Rev).To(Equal(uint64(1)))
+		Expect(persisted().Rev).To(Equal(uint64(1)))
+		Expect(persisted().Value).To(Equal(200))
+	})
+
+	It("increments the field when it is omitted", func() {
+		m.Value = 200
+		Expect(db.Omit("Rev").Updates(m).Error).To(Succeed())
+		Expect(persisted().Rev).To(Equal(uint64(1)))
+
+		stale.Value = 300
+		Expect(db.Omit("rev").Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(200))
+	})
+
+	It("detects concurrent modification", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		stale.Value = 300
+		err := db.Updates(stale).Error
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.ExpectedVersion).To(BeNumerically("==", 0))
+
+		// the failed update leaves the field at the version it was read at, rather than the version it would have
+		// been written at, which would let the retried update overwrite the concurrent modification
+		Expect(stale.Rev).To(BeNumerically("==", 0))
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		p := persisted()
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Rev).To(BeNumerically("==", 1))
+	})
+
+	It("detects concurrent modification when soft deleting, incrementing the field", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		p := persisted()
+		Expect(p.DeletedAt.Valid).To(BeTrue())
+		Expect(p.Rev).To(BeNumerically("==", 2))
+	})
+
+	It("tracks the version of each model in a slice", func() {
+		Expect(db.Create(&RevisionedModel{ID: TestID + 1, Value: 100}).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		var models []RevisionedModel
+		Expect(db.Order("id").Find(&models).Error).To(Succeed())
+		for i := range models {
+			models[i].Value += 1
+			Expect(db.Updates(&models[i]).Error).To(Succeed())
+		}
+		Expect(models[0].Rev).To(BeNumerically("==", 2))
+		Expect(models[1].Rev).To(BeNumerically("==", 1))
+	})
+
+	It("is not guarded without the plugin", func() {
+		plain, closePlain := openTestDB()
+		defer closePlain()
+		Expect(plain.AutoMigrate(&RevisionedModel{})).To(Succeed())
+		Expect(plain.Create(&RevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+
+		unguarded := &RevisionedModel{}
+		Expect(plain.First(unguarded, TestID).Error).To(Succeed())
+		unguarded.Value = 200
+		Expect(plain.Updates(unguarded).Error).To(Succeed())
+		Expect(unguarded.Rev).To(BeNumerically("==", 0))
+	})
+})
+
+// SignedRevisionedModel uses a signed field of its own as its version
+type SignedRevisionedModel struct {
+	ID      uint
+	Value   int
+	Version int64 `gorm:"optimisticlock"`
+}
+
+var _ = Describe("Signed tagged version fields", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m, stale *SignedRevisionedModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		Expect(db.AutoMigrate(&SignedRevisionedModel{})).To(Succeed())
+
+		Expect(db.Create(&SignedRevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+
+		m = &SignedRevisionedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &SignedRevisionedModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *SignedRevisionedModel {
+		p := &SignedRevisionedModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	setVersion := func(version int64) {
+		Expect(db.Model(&SignedRevisionedModel{ID: TestID}).UpdateColumn("version", version).Error).To(Succeed())
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	}
+
+	It("increments the field on every update", func() {
+		for version := int64(1); version <= 3; version++ {
+			m.Value += 1
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(Equal(version))
+			Expect(persisted().Version).To(Equal(version))
+		}
+	})
+
+	It("detects concurrent modification", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(stale.Version).To(BeNumerically("==", 0))
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		p := persisted()
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Version).To(BeNumerically("==", 1))
+	})
+
+	It("refuses to increment the field past the largest signed version", func() {
+		setVersion(math.MaxInt64)
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrVersionOverflow))
+		Expect(m.Version).To(BeNumerically("==", math.MaxInt64))
+		Expect(persisted().Value).To(Equal(100))
+	})
+
+	It("refuses to update a model at a negative version", func() {
+		setVersion(-1)
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrNegativeVersion))
+		Expect(m.Version).To(BeNumerically("==", -1))
+		Expect(persisted().Value).To(Equal(100))
+	})
+
+	It("guards hard deletes of a model at a negative version", func() {
+		setVersion(-1)
+		stale.Version = -2
+
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Delete(m).Error).To(Succeed())
+		Expect(db.First(&SignedRevisionedModel{}, TestID).Error).To(MatchError(gorm.ErrRecordNotFound))
+	})
+})
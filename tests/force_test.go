@@ -0,0 +1,73 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("ForceUpdate", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("updates a stale model, still incrementing the stored version", func() {
+		stale.Value = 300
+		Expect(db.Scopes(optimistic.ForceUpdate).Updates(stale).Error).To(Succeed())
+		Expect(stale.Version).To(BeNumerically("==", 3))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("soft deletes a stale model, still incrementing the stored version", func() {
+		Expect(db.Scopes(optimistic.ForceUpdate).Delete(stale).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.DeletedAt.Valid).To(BeTrue())
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("hard deletes a stale model", func() {
+		Expect(db.Scopes(optimistic.ForceUpdate).Unscoped().Delete(stale).Error).To(Succeed())
+
+		var count int64
+		Expect(db.Unscoped().Model(&TestModel{}).Count(&count).Error).To(Succeed())
+		Expect(count).To(BeNumerically("==", 0))
+	})
+
+	It("only affects the scoped statement", func() {
+		alsoStale := &TestModel{}
+		*alsoStale = *stale
+
+		stale.Value = 300
+		Expect(db.Scopes(optimistic.ForceUpdate).Updates(stale).Error).To(Succeed())
+
+		alsoStale.Value = 400
+		Expect(db.Updates(alsoStale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+})
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("DescribeGuard", func() {
+	var db, dryRun *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(db.First(m, TestID).Error).To(Succeed())
+
+		dryRun = db.Session(&gorm.Session{DryRun: true})
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectUnwritten := func() {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	}
+
+	It("describes the guard of an update without making it", func() {
+		m.Value = 300
+		result := dryRun.Updates(m)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).To(Equal("version = 2, setting version to 3"))
+		expectUnwritten()
+	})
+
+	It("describes the guard of a delete without making it", func() {
+		result := dryRun.Delete(m)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).To(Equal("version = 2, setting version to 3"))
+
+		result = dryRun.Unscoped().Delete(m)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).To(Equal("version = 2"))
+		expectUnwritten()
+	})
+
+	It("describes the guard of a write that was made", func() {
+		m.Value = 300
+		result := db.Updates(m)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).To(Equal("version = 2, setting version to 3"))
+	})
+
+	It("describes unchecked writes", func() {
+		m.Value = 300
+		result := optimistic.WithoutVersionCheck(dryRun).Updates(m)
+		Expect(optimistic.DescribeGuard(result)).To(Equal("no version check, setting version to 3"))
+	})
+
+	It("describes forced writes", func() {
+		stale := &TestModel{Model: gorm.Model{ID: TestID}, Value: 300}
+		result := db.Scopes(optimistic.ForceUpdate).Updates(stale)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).
+			To(Equal("version = 2 (forced, the value currently in the database), setting version to 3"))
+	})
+
+	It("describes nothing for writes that were not guarded", func() {
+		result := dryRun.Model(&LegacyModel{Model: gorm.Model{ID: TestID}}).UpdateColumn("value", 300)
+		Expect(result.Error).To(Succeed())
+		Expect(optimistic.DescribeGuard(result)).To(BeEmpty())
+	})
+})
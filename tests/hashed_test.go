@@ -0,0 +1,142 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type HashedModel struct {
+	gorm.Model
+	optimistic.Hashed
+
+	Value int
+	Notes string
+}
+
+type SelectivelyHashedModel struct {
+	gorm.Model
+	optimistic.Hashed
+
+	Value int `optimistic:"hash"`
+	Notes string
+}
+
+var _ = Describe("Hashed", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&HashedModel{}, &SelectivelyHashedModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	When("an entry is created", func() {
+		var m *HashedModel
+
+		JustBeforeEach(func() {
+			m = &HashedModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+		})
+
+		It("persists the hash of its content", func() {
+			Expect(m.ContentHash).NotTo(BeEmpty())
+			Expect(m.ReadHash()).To(Equal(m.ContentHash))
+
+			persisted := &HashedModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.ContentHash).To(Equal(m.ContentHash))
+		})
+
+		It("persists a new hash when the content changes", func() {
+			created := m.ContentHash
+
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.ContentHash).NotTo(Equal(created))
+
+			persisted := &HashedModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.ContentHash).To(Equal(m.ContentHash))
+		})
+
+		It("detects the loser of two readers racing to update", func() {
+			a := &HashedModel{}
+			b := &HashedModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			b.Value = 300
+			Expect(db.Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+			persisted := &HashedModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(200))
+		})
+
+		It("detects the loser of two readers racing to update with a map", func() {
+			a := &HashedModel{}
+			b := &HashedModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			Expect(db.Model(a).Updates(map[string]interface{}{"value": 200}).Error).To(Succeed())
+			Expect(db.Model(b).Updates(map[string]interface{}{"value": 300}).Error).
+				To(MatchError(optimistic.ErrConcurrentModification))
+		})
+
+		It("detects deleting a concurrently modified entry", func() {
+			a := &HashedModel{}
+			b := &HashedModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			Expect(db.Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(db.Unscoped().Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+	})
+
+	When("only some fields participate in the hash", func() {
+		JustBeforeEach(func() {
+			Expect(db.Create(&SelectivelyHashedModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		})
+
+		It("detects concurrent modification of a participating field", func() {
+			a := &SelectivelyHashedModel{}
+			b := &SelectivelyHashedModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			b.Value = 300
+			Expect(db.Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+
+		It("ignores concurrent modification of other fields", func() {
+			a := &SelectivelyHashedModel{}
+			b := &SelectivelyHashedModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Notes = "first"
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			b.Notes = "second"
+			Expect(db.Updates(b).Error).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB creates a fresh sqlite database in a temporary directory, returning it along with a function that closes
+// the database and removes the directory again
+func openTestDB() (*gorm.DB, func()) {
+	log.SetOutput(GinkgoWriter)
+
+	tempDir, err := ioutil.TempDir("", "tests-")
+	Expect(err).To(Succeed())
+	log.Printf("created temporary directory file://%s", tempDir)
+
+	dbPath := path.Join(tempDir, "test.sqlite3")
+	log.Printf("creating database at file://%s", dbPath)
+	dialector := sqlite.Open(dbPath)
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.New(log.New(GinkgoWriter, "\r\n", log.LstdFlags), logger.Config{
+			LogLevel: logger.Info,
+			Colorful: true,
+		}),
+		SkipDefaultTransaction: true,
+	})
+	Expect(err).To(Succeed())
+	db = db.Debug()
+
+	sqliteDB, err := db.DB()
+	Expect(err).To(Succeed())
+	sqliteDB.SetMaxOpenConns(1)
+
+	closeDB := func() {
+		log.Printf("closing database")
+		sqliteDB, err := db.DB()
+		Expect(err).To(Succeed())
+		Expect(sqliteDB.Close()).To(Succeed())
+
+		log.Printf("removing temporary directory file://%s", tempDir)
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	}
+
+	return db, closeDB
+}
+
+// statementCounter is a GORM logger counting the statements executed through it, discarding everything it is given
+type statementCounter struct {
+	logger.Interface
+	count *int64
+}
+
+func newStatementCounter() *statementCounter {
+	return &statementCounter{Interface: logger.Discard, count: new(int64)}
+}
+
+func (c *statementCounter) LogMode(level logger.LogLevel) logger.Interface {
+	return c
+}
+
+func (c *statementCounter) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	atomic.AddInt64(c.count, 1)
+}
+
+// Count returns the number of statements executed so far
+func (c *statementCounter) Count() int64 {
+	return atomic.LoadInt64(c.count)
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("ETagMiddleware", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var server *httptest.Server
+	var etag string
+	var writeErr error
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		created := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(created).Error).To(Succeed())
+		etag = optimistic.ETag(&created.Versioned)
+
+		// sets the model's value to the request body
+		handler := func(w http.ResponseWriter, r *http.Request) (*optimistic.Versioned, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.Atoi(string(body))
+			if err != nil {
+				return nil, err
+			}
+
+			m := &TestModel{}
+			if err := db.First(m, TestID).Error; err != nil {
+				return nil, err
+			}
+			optimistic.ApplyIfMatch(r, &m.Versioned)
+			m.Value = value
+			if writeErr = db.WithContext(r.Context()).Updates(m).Error; writeErr != nil {
+				return nil, writeErr
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("updated"))
+			return &m.Versioned, nil
+		}
+		server = httptest.NewServer(optimistic.ETagMiddleware(handler))
+	})
+
+	JustAfterEach(func() {
+		server.Close()
+		closeDB()
+	})
+
+	update := func(value int, ifMatch ...string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(strconv.Itoa(value)))
+		Expect(err).To(Succeed())
+		for _, header := range ifMatch {
+			req.Header.Add("If-Match", header)
+		}
+
+		resp, err := server.Client().Do(req)
+		Expect(err).To(Succeed())
+		return resp
+	}
+
+	body := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		Expect(err).To(Succeed())
+		return string(data)
+	}
+
+	persistedValue := func() int {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		return m.Value
+	}
+
+	It("applies the write when If-Match matches the current version", func() {
+		resp := update(200, etag)
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Expect(body(resp)).To(Equal("updated"))
+		Expect(persistedValue()).To(Equal(200))
+
+		newETag := resp.Header.Get("ETag")
+		Expect(newETag).NotTo(Equal(etag))
+		Expect(newETag).To(Equal(optimistic.ETag(&optimistic.Versioned{Version: 2})))
+
+		// the new ETag can be used for the next write
+		resp = update(300, newETag)
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Expect(persistedValue()).To(Equal(300))
+	})
+
+	It("fails the precondition when If-Match does not match the current version", func() {
+		Expect(update(200, etag).StatusCode).To(Equal(http.StatusAccepted))
+
+		resp := update(300, etag)
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(resp.Header.Get("ETag")).To(BeEmpty())
+		Expect(body(resp)).NotTo(ContainSubstring("updated"))
+		Expect(persistedValue()).To(Equal(200))
+	})
+
+	It("gives the conflict the status code of a failed precondition", func() {
+		Expect(update(200, etag).StatusCode).To(Equal(http.StatusAccepted))
+		Expect(update(300, etag).StatusCode).To(Equal(http.StatusPreconditionFailed))
+
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(writeErr, &conflict)).To(BeTrue())
+		Expect(conflict.StatusCode()).To(Equal(http.StatusPreconditionFailed))
+	})
+
+	It("applies the write when If-Match is missing", func() {
+		resp := update(200)
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Expect(resp.Header.Get("ETag")).To(Equal(optimistic.ETag(&optimistic.Versioned{Version: 2})))
+		Expect(persistedValue()).To(Equal(200))
+	})
+
+	It("applies the write when If-Match matches any version", func() {
+		Expect(update(200, "*").StatusCode).To(Equal(http.StatusAccepted))
+		Expect(persistedValue()).To(Equal(200))
+	})
+
+	It("fails the precondition when If-Match cannot be satisfied", func() {
+		for _, ifMatch := range [][]string{
+			{"W/" + etag},
+			{strings.Trim(etag, `"`)},
+			{`"not a token"`},
+			{etag + ", " + etag},
+			{etag, etag},
+		} {
+			Expect(update(200, ifMatch...).StatusCode).To(Equal(http.StatusPreconditionFailed), "If-Match: %v", ifMatch)
+		}
+		Expect(persistedValue()).To(Equal(100))
+	})
+
+	It("reports other errors as internal server errors", func() {
+		req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("not a number"))
+		Expect(err).To(Succeed())
+		resp, err := server.Client().Do(req)
+		Expect(err).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Initial versions", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var initial uint64
+
+	BeforeEach(func() {
+		initial = 0
+	})
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{InitialVersion: &initial}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{}, &RevisionedModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("creates models at the initial version", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 0))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 0))
+		Expect(persisted().Version).To(BeNumerically("==", 0))
+	})
+
+	It("creates each model of a slice at the initial version", func() {
+		models := []*TestModel{{Value: 100}, {Value: 200}}
+		Expect(db.Create(&models).Error).To(Succeed())
+		for _, m := range models {
+			Expect(m.Version).To(BeNumerically("==", 0))
+		}
+	})
+
+	It("updates models created at the initial version", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(m).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 1))
+
+		read := persisted()
+		Expect(read.Version).To(BeNumerically("==", 1))
+		read.Value = 300
+		Expect(db.Updates(read).Error).To(Succeed())
+		Expect(persisted().Version).To(BeNumerically("==", 2))
+	})
+
+	It("detects concurrent modification of models read at the initial version", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		m := persisted()
+		stale := persisted()
+		Expect(stale.ReadVersion()).To(BeNumerically("==", 0))
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("still requires models to be read before they are updated", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrUnknownReadVersion))
+	})
+
+	It("leaves versions set on created models alone", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Versioned: optimistic.Versioned{Version: 5}}
+		Expect(db.Create(m).Error).To(Succeed())
+		Expect(persisted().Version).To(BeNumerically("==", 5))
+	})
+
+	When("the initial version is not 0", func() {
+		BeforeEach(func() {
+			initial = 10
+		})
+
+		It("creates models at the initial version", func() {
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 10))
+			Expect(persisted().Version).To(BeNumerically("==", 10))
+		})
+
+		It("creates models with tagged version fields at the initial version", func() {
+			m := &RevisionedModel{ID: TestID, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+			Expect(m.Rev).To(BeNumerically("==", 10))
+
+			read := &RevisionedModel{}
+			Expect(db.First(read, TestID).Error).To(Succeed())
+			Expect(read.Rev).To(BeNumerically("==", 10))
+			read.Value = 200
+			Expect(db.Updates(read).Error).To(Succeed())
+			Expect(read.Rev).To(BeNumerically("==", 11))
+		})
+	})
+})
@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// capturingLogger records the messages logged through it at or above its level, ignoring SQL traces
+type capturingLogger struct {
+	level    logger.LogLevel
+	mu       *sync.Mutex
+	messages *[]string
+}
+
+func newCapturingLogger(level logger.LogLevel) *capturingLogger {
+	return &capturingLogger{level: level, mu: &sync.Mutex{}, messages: &[]string{}}
+}
+
+func (l *capturingLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.level = level
+	return &copied
+}
+
+func (l *capturingLogger) log(level logger.LogLevel, msg string, data ...interface{}) {
+	if l.level < level {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.messages = append(*l.messages, fmt.Sprintf(msg, data...))
+}
+
+func (l *capturingLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.log(logger.Info, msg, data...)
+}
+
+func (l *capturingLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.log(logger.Warn, msg, data...)
+}
+
+func (l *capturingLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.log(logger.Error, msg, data...)
+}
+
+func (l *capturingLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+}
+
+func (l *capturingLogger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), *l.messages...)
+}
+
+var _ = Describe("Logging version transitions", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var capture *capturingLogger
+	var opts optimistic.PluginOptions
+
+	BeforeEach(func() {
+		capture = newCapturingLogger(logger.Info)
+		opts = optimistic.PluginOptions{LogVersionTransitions: true}
+	})
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(opts))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		db = db.Session(&gorm.Session{Logger: capture})
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	writeAndDelete := func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		Expect(db.Unscoped().First(m, TestID).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(m).Error).To(Succeed())
+	}
+
+	It("logs updates and deletes", func() {
+		writeAndDelete()
+		Expect(capture.Messages()).To(Equal([]string{
+			"optimistic: updated test_models 1 from version 1 to 2",
+			"optimistic: soft deleted test_models 1 from version 2 to 3",
+			"optimistic: hard deleted test_models 1 at version 3",
+		}))
+	})
+
+	It("does not log conflicts", func() {
+		stale := &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		before := len(capture.Messages())
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(capture.Messages()).To(HaveLen(before))
+	})
+
+	When("the logger is configured to log less", func() {
+		BeforeEach(func() {
+			capture = newCapturingLogger(logger.Warn)
+		})
+
+		It("does not log", func() {
+			writeAndDelete()
+			Expect(capture.Messages()).To(BeEmpty())
+		})
+	})
+
+	When("the option is not set", func() {
+		BeforeEach(func() {
+			opts.LogVersionTransitions = false
+		})
+
+		It("does not log", func() {
+			writeAndDelete()
+			Expect(capture.Messages()).To(BeEmpty())
+		})
+	})
+})
+
+// capturingOptimisticLogger records the warnings and debug messages logged through it
+type capturingOptimisticLogger struct {
+	mu       sync.Mutex
+	warnings []string
+	debug    []string
+}
+
+func (l *capturingOptimisticLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *capturingOptimisticLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func (l *capturingOptimisticLogger) Warnings() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.warnings...)
+}
+
+func (l *capturingOptimisticLogger) Debug() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.debug...)
+}
+
+var _ = Describe("Logging diagnostics through the plugin's Logger", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var capture *capturingOptimisticLogger
+
+	BeforeEach(func() {
+		capture = &capturingOptimisticLogger{}
+
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{Logger: capture}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		// the package's diagnostics do not depend on GORM's logger
+		db = db.Session(&gorm.Session{Logger: logger.Discard})
+	})
+
+	AfterEach(func() {
+		closeDB()
+	})
+
+	It("warns of conflicts", func() {
+		stale := &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		Expect(db.Model(&TestModel{}).Where("id = ?", TestID).UpdateColumn("version", 2).Error).To(Succeed())
+
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(capture.Warnings()).To(Equal([]string{
+			"optimistic: update conflicted: concurrent modification detected: test_models with primary key 1 is no " +
+				"longer at version 1",
+		}))
+	})
+
+	It("notes retries", func() {
+		attempts := 0
+		err := optimistic.RunWithRetry(db, 2, func(tx *gorm.DB) error {
+			attempts++
+			if attempts == 1 {
+				return optimistic.ErrConcurrentModification
+			}
+			return nil
+		})
+		Expect(err).To(Succeed())
+		Expect(capture.Debug()).To(HaveLen(1))
+		Expect(capture.Debug()[0]).To(HavePrefix("optimistic: retrying after attempt 1 of 2 conflicted"))
+	})
+
+	It("logs nothing for writes that do not conflict", func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(capture.Warnings()).To(BeEmpty())
+		Expect(capture.Debug()).To(BeEmpty())
+	})
+})
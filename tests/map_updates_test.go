@@ -0,0 +1,68 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Map based updates", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("increments the version", func() {
+		Expect(db.Model(m).Updates(map[string]interface{}{"value": 5}).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(5))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("increments the version when updating a single column", func() {
+		Expect(db.Model(m).Update("value", 5).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(5))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("does not modify the caller's map", func() {
+		values := map[string]interface{}{"value": 5}
+		Expect(db.Model(m).Updates(values).Error).To(Succeed())
+		Expect(values).To(Equal(map[string]interface{}{"value": 5}))
+	})
+
+	It("detects concurrent modification", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		Expect(db.Model(other).Updates(map[string]interface{}{"value": 200}).Error).To(Succeed())
+
+		Expect(db.Model(m).Updates(map[string]interface{}{"value": 300}).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+})
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Metrics", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &EtagModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Create(&EtagModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		optimistic.Metrics.Reset()
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("counts conflicting and non-conflicting operations per table", func() {
+		for i := 0; i < 3; i++ {
+			m := &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			m.Value += 1
+			Expect(db.Updates(m).Error).To(Succeed())
+
+			// m is now stale
+			m.Value += 1
+			Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		}
+
+		e := &EtagModel{}
+		Expect(db.First(e, TestID).Error).To(Succeed())
+		stale := &EtagModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		Expect(db.Delete(e).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		// a write finding its row missing is not a conflict
+		Expect(db.Delete(e).Error).To(MatchError(gorm.ErrRecordNotFound))
+
+		snapshot := optimistic.Metrics.Snapshot()
+		Expect(snapshot.Tables).To(Equal(map[string]optimistic.TableMetrics{
+			"test_models": {UpdatesTotal: 3, ConflictsTotal: 3},
+			"etag_models": {DeletesTotal: 1, ConflictsTotal: 1},
+		}))
+		Expect(snapshot.UpdatesTotal).To(BeNumerically("==", 3))
+		Expect(snapshot.DeletesTotal).To(BeNumerically("==", 1))
+		Expect(snapshot.ConflictsTotal).To(BeNumerically("==", 4))
+	})
+
+	It("returns a copy that is unaffected by later operations", func() {
+		snapshot := optimistic.Metrics.Snapshot()
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value += 1
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		Expect(snapshot.UpdatesTotal).To(BeNumerically("==", 0))
+		Expect(optimistic.Metrics.Snapshot().UpdatesTotal).To(BeNumerically("==", 1))
+	})
+})
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// LegacyModel is TestModel as it was before adopting optimistic locking
+type LegacyModel struct {
+	gorm.Model
+
+	Value int
+}
+
+func (LegacyModel) TableName() string {
+	return "test_models"
+}
+
+var _ = Describe("EnsureVersionColumn", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&LegacyModel{})).To(Succeed())
+
+		Expect(db.Create(&LegacyModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Create(&LegacyModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectVersionedRows := func() {
+		var models []*TestModel
+		Expect(db.Find(&models).Error).To(Succeed())
+		Expect(models).To(HaveLen(2))
+		for _, m := range models {
+			Expect(m.Version).To(BeNumerically("==", 1))
+		}
+
+		models[0].Value = 200
+		Expect(db.Updates(models[0]).Error).To(Succeed())
+		Expect(models[0].Version).To(BeNumerically("==", 2))
+	}
+
+	It("adds the version column to an existing table", func() {
+		Expect(db.Migrator().HasColumn(&TestModel{}, "version")).To(BeFalse())
+
+		Expect(optimistic.EnsureVersionColumn(db, &TestModel{})).To(Succeed())
+
+		Expect(db.Migrator().HasColumn(&TestModel{}, "version")).To(BeTrue())
+		expectVersionedRows()
+	})
+
+	It("backfills rows without a version when the column already exists", func() {
+		Expect(db.Exec("ALTER TABLE test_models ADD COLUMN version integer").Error).To(Succeed())
+
+		Expect(optimistic.EnsureVersionColumn(db, &TestModel{})).To(Succeed())
+
+		expectVersionedRows()
+	})
+
+	It("can be run again without changing any versions", func() {
+		Expect(optimistic.EnsureVersionColumn(db, &TestModel{})).To(Succeed())
+		expectVersionedRows()
+
+		Expect(optimistic.EnsureVersionColumn(db, &TestModel{})).To(Succeed())
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+	})
+
+	It("requires a model with a version number", func() {
+		Expect(optimistic.EnsureVersionColumn(db, &UnlockedModel{})).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
+
+var _ = Describe("ValidateVersionColumns", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&LegacyModel{}, &RevisionedModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("lists the tables missing their version columns", func() {
+		err := optimistic.ValidateVersionColumns(db, &RevisionedModel{}, &TestModel{}, &HashedModel{})
+		var missing *optimistic.MissingVersionColumnError
+		Expect(errors.As(err, &missing)).To(BeTrue())
+		Expect(missing.Columns).To(Equal([]string{"test_models.version", "hashed_models.content_hash"}))
+		Expect(err).To(MatchError(optimistic.ErrVersionColumnMissing))
+	})
+
+	It("succeeds once the version columns exist", func() {
+		Expect(optimistic.EnsureVersionColumn(db, &TestModel{})).To(Succeed())
+		Expect(optimistic.ValidateVersionColumns(db, &TestModel{}, &RevisionedModel{})).To(Succeed())
+	})
+
+	It("rejects models that are not locked", func() {
+		Expect(optimistic.ValidateVersionColumns(db, &LegacyModel{})).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
+
+var _ = Describe("Writes to tables without a version column", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		Expect(db.AutoMigrate(&LegacyModel{})).To(Succeed())
+		Expect(db.Create(&LegacyModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectMissingColumn := func(err error) {
+		Expect(err).To(MatchError(optimistic.ErrVersionColumnMissing))
+		var missing *optimistic.MissingVersionColumnError
+		Expect(errors.As(err, &missing)).To(BeTrue())
+		Expect(missing.Columns).To(Equal([]string{"test_models.version"}))
+		Expect(missing.Err).To(HaveOccurred())
+	}
+
+	It("fails updates with ErrVersionColumnMissing", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+		m.SetReadVersion(1)
+		expectMissingColumn(db.Updates(m).Error)
+	})
+
+	It("fails deletes with ErrVersionColumnMissing", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}}
+		m.SetReadVersion(1)
+		expectMissingColumn(db.Delete(m).Error)
+	})
+
+	It("fails creates with ErrVersionColumnMissing", func() {
+		expectMissingColumn(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error)
+	})
+
+	It("leaves other errors alone", func() {
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+		m.SetReadVersion(1)
+		err := db.Model(m).Update("no_such_field", 1).Error
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(MatchError(optimistic.ErrVersionColumnMissing))
+	})
+})
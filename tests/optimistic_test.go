@@ -1,17 +1,13 @@
 package tests
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
-	"path"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
 	"github.com/omaskery/optimistic-gorm/optimistic"
 )
@@ -29,46 +25,29 @@ type TestModel struct {
 }
 
 var _ = Describe("Tests", func() {
-	var tempDir string
-	var db *gorm.DB
+	describeTests(false)
 
-	JustBeforeEach(func() {
-		log.SetOutput(GinkgoWriter)
-
-		var err error
-
-		tempDir, err = ioutil.TempDir("", "tests-")
-		Expect(err).To(Succeed())
-		log.Printf("created temporary directory file://%s", tempDir)
-
-		dbPath := path.Join(tempDir, "test.sqlite3")
-		log.Printf("creating database at file://%s", dbPath)
-		dialector := sqlite.Open(dbPath)
-		db, err = gorm.Open(dialector, &gorm.Config{
-			Logger: logger.New(log.New(GinkgoWriter, "\r\n", log.LstdFlags), logger.Config{
-				LogLevel: logger.Info,
-				Colorful: true,
-			}),
-			SkipDefaultTransaction: true,
-		})
-		Expect(err).To(Succeed())
-		db = db.Debug()
+	When("using prepared statements", func() {
+		describeTests(true)
+	})
+})
 
-		sqliteDB, err := db.DB()
-		Expect(err).To(Succeed())
-		sqliteDB.SetMaxOpenConns(1)
+// describeTests describes the behaviour of Versioned models, optionally with GORM caching the prepared statements it
+// executes, which must not reuse a statement built without the clauses guarding a write
+func describeTests(prepared bool) {
+	var db *gorm.DB
+	var closeDB func()
 
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		if prepared {
+			db = db.Session(&gorm.Session{PrepareStmt: true})
+		}
 		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
 	})
 
 	JustAfterEach(func() {
-		log.Printf("closing database")
-		sqliteDB, err := db.DB()
-		Expect(err).To(Succeed())
-		Expect(sqliteDB.Close()).To(Succeed())
-
-		log.Printf("removing temporary directory file://%s", tempDir)
-		Expect(os.RemoveAll(tempDir)).To(Succeed())
+		closeDB()
 	})
 
 	It("attempting to find a non-existent model doesn't break", func() {
@@ -170,6 +149,22 @@ var _ = Describe("Tests", func() {
 			})).To(Succeed())
 		})
 
+		It("still guards 'hard' deletion of a stale model", func() {
+			stale := &TestModel{}
+			Expect(db.First(stale, TestID).Error).To(Succeed())
+
+			fresh := &TestModel{}
+			Expect(db.First(fresh, TestID).Error).To(Succeed())
+			fresh.Value += 1
+			Expect(db.Updates(fresh).Error).To(Succeed())
+
+			Expect(db.Unscoped().Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+			var count int64
+			Expect(db.Unscoped().Model(&TestModel{}).Where("id = ?", TestID).Count(&count).Error).To(Succeed())
+			Expect(count).To(BeNumerically("==", 1))
+		})
+
 		When("there are concurrent modifications", func() {
 			updating := func(model *TestModel, tx *gorm.DB) error {
 				model.Value += 100
@@ -187,9 +182,52 @@ var _ = Describe("Tests", func() {
 				"soft-deletion": softDeletion,
 				"hard-deletion": hardDeletion,
 			}
+			operations := map[string]optimistic.Operation{
+				"updating":      optimistic.OperationUpdate,
+				"soft-deletion": optimistic.OperationSoftDelete,
+				"hard-deletion": optimistic.OperationHardDelete,
+			}
+
+			// deleting the model leaves nothing for the other modification to conflict with, unless it is a hard
+			// deletion which can still see the soft deleted row
+			deletes := func(aName, bName string) bool {
+				return aName == "hard-deletion" || (aName == "soft-deletion" && bName != "hard-deletion")
+			}
 
 			for aName, aModification := range modifications {
+				aName, aModification := aName, aModification
 				for bName, bModification := range modifications {
+					bName, bModification := bName, bModification
+					if deletes(aName, bName) {
+						It(fmt.Sprintf("reports the row missing [%s vs %s]", aName, bName), func() {
+							a := &TestModel{}
+							b := &TestModel{}
+
+							Expect(db.Transaction(func(tx *gorm.DB) error {
+								Expect(tx.Where("id = ?", TestID).First(a).Error).To(Succeed())
+								Expect(tx.Where("id = ?", TestID).First(b).Error).To(Succeed())
+								return nil
+							})).To(Succeed())
+
+							Expect(db.Transaction(func(tx *gorm.DB) error {
+								return aModification(a, tx)
+							})).To(Succeed())
+
+							err := db.Transaction(func(tx *gorm.DB) error {
+								return bModification(b, tx)
+							})
+							Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+							Expect(optimistic.IsConflict(err)).To(BeFalse())
+
+							var missing *optimistic.MissingRowError
+							Expect(errors.As(err, &missing)).To(BeTrue())
+							Expect(missing.Table).To(Equal("test_models"))
+							Expect(missing.PrimaryKey).To(BeEquivalentTo(TestID))
+							Expect(missing.Operation).To(Equal(operations[bName]))
+						})
+						continue
+					}
+
 					It(fmt.Sprintf("detects [%s vs %s]", aName, bName), func() {
 						a := &TestModel{}
 						b := &TestModel{}
@@ -209,8 +247,34 @@ var _ = Describe("Tests", func() {
 							return bModification(b, tx)
 						})).To(MatchError(optimistic.ErrConcurrentModification))
 					})
+
+					It(fmt.Sprintf("describes the conflict [%s vs %s]", aName, bName), func() {
+						a := &TestModel{}
+						b := &TestModel{}
+
+						Expect(db.Transaction(func(tx *gorm.DB) error {
+							Expect(tx.Where("id = ?", TestID).First(a).Error).To(Succeed())
+							Expect(tx.Where("id = ?", TestID).First(b).Error).To(Succeed())
+							return nil
+						})).To(Succeed())
+
+						Expect(db.Transaction(func(tx *gorm.DB) error {
+							return aModification(a, tx)
+						})).To(Succeed())
+
+						err := db.Transaction(func(tx *gorm.DB) error {
+							return bModification(b, tx)
+						})
+
+						var conflict *optimistic.ConflictError
+						Expect(errors.As(err, &conflict)).To(BeTrue())
+						Expect(conflict.Table).To(Equal("test_models"))
+						Expect(conflict.PrimaryKey).To(BeEquivalentTo(TestID))
+						Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+						Expect(conflict.Operation).To(Equal(operations[bName]))
+					})
 				}
 			}
 		})
 	})
-})
+}
@@ -0,0 +1,359 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// HookedModel defines its own hooks, shadowing those of Versioned without calling through to them
+type HookedModel struct {
+	gorm.Model
+	optimistic.Versioned
+
+	Value int
+
+	hookCalls []string `gorm:"-"`
+}
+
+func (m *HookedModel) BeforeUpdate(tx *gorm.DB) error {
+	m.hookCalls = append(m.hookCalls, "BeforeUpdate")
+	return nil
+}
+
+func (m *HookedModel) AfterUpdate(tx *gorm.DB) error {
+	m.hookCalls = append(m.hookCalls, "AfterUpdate")
+	return nil
+}
+
+func (m *HookedModel) BeforeDelete(tx *gorm.DB) error {
+	m.hookCalls = append(m.hookCalls, "BeforeDelete")
+	return nil
+}
+
+func (m *HookedModel) AfterFind(tx *gorm.DB) error {
+	m.hookCalls = append(m.hookCalls, "AfterFind")
+	return nil
+}
+
+var _ = Describe("Plugin", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var metrics *optimistic.MetricsRegistry
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		metrics = &optimistic.MetricsRegistry{}
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{
+			Retry:   optimistic.RetryOptions{BaseDelay: 20 * time.Millisecond},
+			Metrics: metrics,
+		}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{}, &HookedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Create(&HookedModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("guards updates of models that shadow the Versioned hooks", func() {
+		stale := &HookedModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		Expect(stale.ReadVersion()).To(BeNumerically("==", 1))
+
+		m := &HookedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+		Expect(m.hookCalls).To(Equal([]string{"AfterFind", "BeforeUpdate", "AfterUpdate"}))
+
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		persisted := &HookedModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("guards models relying on the Versioned hooks", func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		Expect(db.Delete(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.DeletedAt.Valid).To(BeTrue())
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("tracks the version of each model in a slice", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+
+		var models []TestModel
+		Expect(db.Order("id").Find(&models).Error).To(Succeed())
+		Expect(models).To(HaveLen(2))
+		for _, m := range models {
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+		}
+	})
+
+	It("tracks the version of each model in every batch", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+
+		var models []TestModel
+		Expect(db.FindInBatches(&models, 1, func(tx *gorm.DB, batch int) error {
+			Expect(models).To(HaveLen(1))
+			Expect(models[0].ReadVersion()).To(BeNumerically("==", 1))
+			models[0].Value = 200
+			return db.Updates(&models[0]).Error
+		}).Error).To(Succeed())
+	})
+
+	It("waits between retries as configured", func() {
+		start := time.Now()
+		err := optimistic.RunWithRetry(db, 2, func(tx *gorm.DB) error {
+			return optimistic.ErrConcurrentModification
+		})
+		Expect(err).To(BeAssignableToTypeOf(&optimistic.RetriesExhaustedError{}))
+		Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+	})
+
+	It("counts writes in the configured metrics registry", func() {
+		optimistic.Metrics.Reset()
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(metrics.Snapshot().Tables).To(Equal(map[string]optimistic.TableMetrics{
+			"test_models": {UpdatesTotal: 1, ConflictsTotal: 1},
+		}))
+		Expect(optimistic.Metrics.Snapshot().Tables).To(BeEmpty())
+	})
+
+	It("rejects models passed by value rather than panicking", func() {
+		Expect(db.AutoMigrate(&RevisionedModel{})).To(Succeed())
+		m := TestModel{}
+		Expect(db.First(&m, TestID).Error).To(Succeed())
+		m.Value = 200
+
+		for _, write := range []func() error{
+			func() error { return db.Updates(m).Error },
+			func() error { return db.Model(m).Updates(map[string]interface{}{"value": 300}).Error },
+			func() error { return db.Save(m).Error },
+			func() error { return db.Delete(m).Error },
+			func() error { return db.Create(TestModel{Value: 100}).Error },
+			func() error { return db.Create(RevisionedModel{Value: 100}).Error },
+			func() error { return db.Updates(RevisionedModel{ID: TestID, Value: 100}).Error },
+		} {
+			var err error
+			Expect(func() { err = write() }).NotTo(Panic())
+			Expect(err).To(MatchError(optimistic.ErrUnaddressableModel))
+		}
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(100))
+		Expect(persisted.Version).To(BeNumerically("==", 1))
+	})
+
+	It("rejects nil models rather than panicking", func() {
+		for _, write := range []func() error{
+			func() error { return db.Updates((*TestModel)(nil)).Error },
+			func() error { return db.Delete((*TestModel)(nil)).Error },
+			func() error { return db.Create((*TestModel)(nil)).Error },
+		} {
+			var err error
+			Expect(func() { err = write() }).NotTo(Panic())
+			Expect(err).To(MatchError(gorm.ErrInvalidValue))
+		}
+	})
+})
+
+var _ = Describe("PanicOnConflict", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{PanicOnConflict: true}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	recovered := func(write func()) (value interface{}) {
+		defer func() {
+			value = recover()
+		}()
+		write()
+		return nil
+	}
+
+	It("panics describing a conflicting update", func() {
+		stale.Value = 300
+		Expect(recovered(func() { db.Updates(stale) })).To(Equal(
+			"optimistic: unhandled conflict: update of test_models with primary key 1 expected version 1"))
+	})
+
+	It("panics describing a conflicting delete", func() {
+		Expect(recovered(func() { db.Delete(stale) })).To(Equal(
+			"optimistic: unhandled conflict: soft delete of test_models with primary key 1 expected version 1"))
+	})
+
+	It("does not panic for writes that do not conflict", func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		m.Value = 300
+		Expect(recovered(func() { Expect(db.Updates(m).Error).To(Succeed()) })).To(BeNil())
+	})
+
+	It("does not panic for conflicts that are resolved", func() {
+		stale.Value = 300
+		Expect(recovered(func() {
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Updates(stale).Error).To(Succeed())
+		})).To(BeNil())
+	})
+})
+
+// OrderedModel defines its own hooks, recording what the version guard had done to the write when each was called
+type OrderedModel struct {
+	gorm.Model
+	optimistic.Versioned
+
+	Value int
+
+	hookCalls []string `gorm:"-"`
+}
+
+func (m *OrderedModel) record(tx *gorm.DB, hook string) {
+	guard := optimistic.DescribeGuard(tx)
+	if guard == "" {
+		guard = "unguarded"
+	}
+	m.hookCalls = append(m.hookCalls, fmt.Sprintf("%s: %s, version %d", hook, guard, m.Version))
+}
+
+func (m *OrderedModel) BeforeSave(tx *gorm.DB) error {
+	m.record(tx, "BeforeSave")
+	return nil
+}
+
+func (m *OrderedModel) BeforeUpdate(tx *gorm.DB) error {
+	m.record(tx, "BeforeUpdate")
+	if m.Value < 0 {
+		return errNegativeValue
+	}
+	return nil
+}
+
+func (m *OrderedModel) AfterUpdate(tx *gorm.DB) error {
+	m.record(tx, "AfterUpdate")
+	return nil
+}
+
+func (m *OrderedModel) BeforeDelete(tx *gorm.DB) error {
+	m.record(tx, "BeforeDelete")
+	return nil
+}
+
+func (m *OrderedModel) AfterDelete(tx *gorm.DB) error {
+	m.record(tx, "AfterDelete")
+	return nil
+}
+
+var errNegativeValue = errors.New("value must not be negative")
+
+var _ = Describe("Plugin callback order", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *OrderedModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+		Expect(db.AutoMigrate(&OrderedModel{})).To(Succeed())
+		Expect(db.Create(&OrderedModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &OrderedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("guards updates after the model's own hooks and checks them before its after hooks", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(m.hookCalls).To(Equal([]string{
+			"BeforeSave: unguarded, version 1",
+			"BeforeUpdate: unguarded, version 1",
+			"AfterUpdate: version = 1, setting version to 2, version 2",
+		}))
+	})
+
+	It("guards deletes after the model's own hooks", func() {
+		Expect(db.Delete(m).Error).To(Succeed())
+		Expect(m.hookCalls).To(Equal([]string{
+			"BeforeDelete: unguarded, version 1",
+			"AfterDelete: version = 1, setting version to 2, version 2",
+		}))
+	})
+
+	It("leaves the version alone when the model's own hook refuses the update", func() {
+		m.Value = -1
+		Expect(db.Updates(m).Error).To(MatchError(errNegativeValue))
+		Expect(m.Version).To(BeNumerically("==", 1))
+
+		persisted := &OrderedModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(100))
+		Expect(persisted.Version).To(BeNumerically("==", 1))
+	})
+
+	It("does not call the model's after hooks when the update conflicts", func() {
+		other := &OrderedModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 300
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(m.hookCalls).To(Equal([]string{
+			"BeforeSave: unguarded, version 1",
+			"BeforeUpdate: unguarded, version 1",
+		}))
+	})
+})
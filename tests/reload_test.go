@@ -0,0 +1,272 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Reload", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("allows a conflicting change to be reapplied", func() {
+		stale.Value += 1
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(optimistic.Reload(db, stale)).To(Succeed())
+		Expect(stale.Value).To(Equal(200))
+		Expect(stale.Version).To(BeNumerically("==", 2))
+		Expect(stale.ReadVersion()).To(BeNumerically("==", 2))
+
+		stale.Value += 1
+		Expect(db.Updates(stale).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(201))
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("returns ErrRecordNotFound if the row has been deleted", func() {
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(m).Error).To(Succeed())
+		Expect(optimistic.Reload(db, stale)).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("requires a primary key", func() {
+		Expect(optimistic.Reload(db, &TestModel{})).To(MatchError(gorm.ErrPrimaryKeyRequired))
+	})
+})
+
+// TriggeredModel has a column that a database trigger keeps up to date, which GORM knows nothing of
+type TriggeredModel struct {
+	gorm.Model
+	optimistic.Versioned
+	Value   int
+	Doubled int
+}
+
+var _ = Describe("UpdateAndRefresh", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TriggeredModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TriggeredModel{})).To(Succeed())
+		Expect(db.Exec(`CREATE TRIGGER double_value AFTER UPDATE OF value ON triggered_models BEGIN
+			UPDATE triggered_models SET doubled = NEW.value * 2 WHERE id = NEW.id;
+		END`).Error).To(Succeed())
+
+		Expect(db.Create(&TriggeredModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TriggeredModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("refreshes the model with the values set by the database", func() {
+		m.Value = 200
+		Expect(optimistic.UpdateAndRefresh(db, m)).To(Succeed())
+		Expect(m.Doubled).To(Equal(400))
+		Expect(m.Version).To(BeNumerically("==", 2))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+	})
+
+	It("allows the model to be written again without conflicting", func() {
+		m.Value = 200
+		Expect(optimistic.UpdateAndRefresh(db, m)).To(Succeed())
+		m.Value = 300
+		Expect(optimistic.UpdateAndRefresh(db, m)).To(Succeed())
+		Expect(m.Doubled).To(Equal(600))
+		Expect(m.Version).To(BeNumerically("==", 3))
+	})
+
+	It("does not refresh the model if the write conflicts", func() {
+		other := &TriggeredModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		m.Value = 300
+		Expect(optimistic.UpdateAndRefresh(db, m)).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(m.Value).To(Equal(300))
+		Expect(m.Doubled).To(Equal(0))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+	})
+})
+
+var _ = Describe("LoadForUpdate", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &UnlockedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 300}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("loads a model found by plucking ready to be updated", func() {
+		var values []int
+		Expect(db.Model(&TestModel{}).Where("value < ?", 200).Pluck("value", &values).Error).To(Succeed())
+		Expect(values).To(Equal([]int{100}))
+
+		m := &TestModel{}
+		Expect(optimistic.LoadForUpdate(db, m, "value = ?", values[0])).To(Succeed())
+		Expect(m.ID).To(Equal(TestID))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("reads every column whatever is selected", func() {
+		m := &TestModel{}
+		Expect(optimistic.LoadForUpdate(db.Select("value"), m, TestID)).To(Succeed())
+		Expect(m.ID).To(Equal(TestID))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+	})
+
+	It("records the version read when hooks are skipped", func() {
+		m := &TestModel{}
+		Expect(optimistic.LoadForUpdate(db.Session(&gorm.Session{SkipHooks: true}), m, TestID)).To(Succeed())
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+	})
+
+	It("detects concurrent modification after loading", func() {
+		m := &TestModel{}
+		Expect(optimistic.LoadForUpdate(db, m, TestID)).To(Succeed())
+
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 300
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("reports missing rows", func() {
+		Expect(optimistic.LoadForUpdate(db, &TestModel{}, NonExistantID)).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("rejects models that are not locked", func() {
+		Expect(optimistic.LoadForUpdate(db, &UnlockedModel{}, TestID)).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
+
+var _ = Describe("LoadBatch", func() {
+	const count = 5
+
+	var db *gorm.DB
+	var closeDB func()
+	var ids []interface{}
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &UnlockedModel{})).To(Succeed())
+
+		ids = nil
+		for id := uint(1); id <= count; id++ {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: id}, Value: 100}).Error).To(Succeed())
+			ids = append(ids, id)
+		}
+
+		// give one row a different version, so that every model tracking the same version would be noticed
+		m := &TestModel{}
+		Expect(db.First(m, 2).Error).To(Succeed())
+		m.Value = 150
+		Expect(db.Updates(m).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("loads every model ready to be updated", func() {
+		models, err := optimistic.LoadBatch[TestModel](db, ids)
+		Expect(err).To(Succeed())
+		Expect(models).To(HaveLen(count))
+		for _, m := range models {
+			Expect(m.ReadVersion()).To(Equal(m.Version))
+			m.Value = 200
+		}
+
+		for _, m := range models {
+			Expect(db.Updates(m).Error).To(Succeed())
+		}
+
+		var persisted []*TestModel
+		Expect(db.Order("id").Find(&persisted).Error).To(Succeed())
+		for _, p := range persisted {
+			Expect(p.Value).To(Equal(200))
+		}
+		Expect(persisted[0].Version).To(BeNumerically("==", 2))
+		Expect(persisted[1].Version).To(BeNumerically("==", 3))
+	})
+
+	It("records the versions read when hooks are skipped", func() {
+		models, err := optimistic.LoadBatch[TestModel](db.Session(&gorm.Session{SkipHooks: true}), ids)
+		Expect(err).To(Succeed())
+		for _, m := range models {
+			Expect(m.ReadVersion()).To(Equal(m.Version))
+			Expect(m.ReadVersion()).NotTo(BeZero())
+		}
+	})
+
+	It("leaves out keys without a row", func() {
+		models, err := optimistic.LoadBatch[TestModel](db, []interface{}{1, NonExistantID})
+		Expect(err).To(Succeed())
+		Expect(models).To(HaveLen(1))
+		Expect(models[0].ID).To(Equal(TestID))
+	})
+
+	It("loads nothing given no keys", func() {
+		models, err := optimistic.LoadBatch[TestModel](db, nil)
+		Expect(err).To(Succeed())
+		Expect(models).To(BeEmpty())
+	})
+
+	It("rejects models that are not locked", func() {
+		_, err := optimistic.LoadBatch[UnlockedModel](db, ids)
+		Expect(err).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
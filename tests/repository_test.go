@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Repository", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var repo *optimistic.Repository[TestModel]
+	var ctx context.Context
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		repo = optimistic.NewRepository[TestModel](db)
+		ctx = context.Background()
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("reads models by their primary key", func() {
+		m, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+		Expect(m.Value).To(Equal(100))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+		_, err = repo.GetByID(ctx, TestID+1)
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("updates and deletes models that have not been modified", func() {
+		m, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+
+		m.Value = 200
+		Expect(repo.Update(ctx, m)).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		persisted, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+
+		Expect(repo.Delete(ctx, persisted)).To(Succeed())
+		_, err = repo.GetByID(ctx, TestID)
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("rejects writes to stale models", func() {
+		stale, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+
+		other, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+		other.Value = 200
+		Expect(repo.Update(ctx, other)).To(Succeed())
+
+		stale.Value = 300
+		err = repo.Update(ctx, stale)
+		Expect(optimistic.IsConflict(err)).To(BeTrue())
+		Expect(repo.Delete(ctx, stale)).To(MatchError(optimistic.ErrConcurrentModification))
+
+		persisted, err := repo.GetByID(ctx, TestID)
+		Expect(err).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+	})
+})
+
+var _ = Describe("UpdateReturningLatest", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("returns the written model if there is no conflict", func() {
+		stale.Value = 200
+		written, err := optimistic.UpdateReturningLatest(db, stale)
+		Expect(err).To(Succeed())
+		Expect(written).To(BeIdenticalTo(stale))
+		Expect(written.Version).To(BeNumerically("==", 2))
+	})
+
+	It("returns the latest version of the model along with the conflict", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		stale.Value = 300
+		latest, err := optimistic.UpdateReturningLatest(db, stale)
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+
+		Expect(latest).NotTo(BeIdenticalTo(stale))
+		Expect(latest.Value).To(Equal(200))
+		Expect(latest.Version).To(BeNumerically("==", 2))
+		Expect(latest.ReadVersion()).To(BeNumerically("==", 2))
+		Expect(stale.Value).To(Equal(300))
+	})
+
+	It("returns no model if the row has been deleted", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		Expect(db.Delete(other).Error).To(Succeed())
+
+		stale.Value = 300
+		latest, err := optimistic.UpdateReturningLatest(db, stale)
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+		Expect(latest).To(BeNil())
+	})
+})
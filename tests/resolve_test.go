@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Resolve", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var first, second *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		first = &TestModel{}
+		Expect(db.First(first, TestID).Error).To(Succeed())
+		second = &TestModel{}
+		Expect(db.First(second, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("rejects conflicting updates by default", func() {
+		first.Value = 200
+		Expect(db.Updates(first).Error).To(Succeed())
+
+		second.Value = 300
+		Expect(optimistic.Resolve(db, optimistic.Reject).Updates(second).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	Context("with LastWriteWins", func() {
+		var conflicts []optimistic.ConflictInfo
+		var unregister func()
+
+		JustBeforeEach(func() {
+			conflicts = nil
+			unregister = optimistic.OnConflict(func(ctx context.Context, info optimistic.ConflictInfo) {
+				conflicts = append(conflicts, info)
+			})
+		})
+
+		JustAfterEach(func() {
+			unregister()
+		})
+
+		It("lets both racing updates succeed, keeping the later write", func() {
+			first.Value = 200
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Updates(first).Error).To(Succeed())
+			Expect(conflicts).To(BeEmpty())
+
+			second.Value = 300
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Updates(second).Error).To(Succeed())
+			Expect(second.Version).To(BeNumerically("==", 3))
+
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].ExpectedVersion).To(BeNumerically("==", 1))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Version).To(BeNumerically("==", 3))
+		})
+
+		It("re-applies map updates", func() {
+			Expect(db.Model(first).Update("value", 200).Error).To(Succeed())
+
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Model(second).Update("value", 300).Error).
+				To(Succeed())
+			Expect(conflicts).To(HaveLen(1))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Version).To(BeNumerically("==", 3))
+		})
+
+		It("still fails if the row has been hard deleted", func() {
+			Expect(db.Unscoped().Delete(first).Error).To(Succeed())
+
+			second.Value = 300
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Updates(second).Error).
+				To(MatchError(gorm.ErrRecordNotFound))
+		})
+
+		It("still fails if the row has been soft deleted", func() {
+			Expect(db.Delete(first).Error).To(Succeed())
+
+			second.Value = 300
+			Expect(optimistic.Resolve(db, optimistic.LastWriteWins).Updates(second).Error).
+				To(MatchError(gorm.ErrRecordNotFound))
+		})
+	})
+})
+
+// CounterModel merges concurrent increments of its count, rather than letting the last write win
+type CounterModel struct {
+	ID uint
+	optimistic.Versioned
+
+	Count int
+	Label string
+
+	increment int
+}
+
+// Increment adds to the count, remembering the increment so that it can be re-applied to the latest count on conflict
+func (c *CounterModel) Increment(n int) {
+	c.Count += n
+	c.increment += n
+}
+
+func (c *CounterModel) MergeFrom(latest interface{}) error {
+	l := latest.(*CounterModel)
+	if l.Label == "frozen" {
+		return errFrozen
+	}
+
+	c.Count = l.Count + c.increment
+	return nil
+}
+
+var errFrozen = errors.New("counter is frozen")
+
+var _ = Describe("ConflictMerger", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var first, second *CounterModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&CounterModel{}, &TestModel{})).To(Succeed())
+
+		Expect(db.Create(&CounterModel{ID: TestID, Count: 10}).Error).To(Succeed())
+
+		first = &CounterModel{}
+		Expect(db.First(first, TestID).Error).To(Succeed())
+		second = &CounterModel{}
+		Expect(db.First(second, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *CounterModel {
+		p := &CounterModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	for _, r := range []optimistic.Resolution{optimistic.Merge, optimistic.LastWriteWins} {
+		r := r
+
+		It(fmt.Sprintf("sums concurrent increments with resolution %d", r), func() {
+			first.Increment(2)
+			Expect(optimistic.Resolve(db, r).Updates(first).Error).To(Succeed())
+
+			second.Increment(3)
+			Expect(optimistic.Resolve(db, r).Updates(second).Error).To(Succeed())
+			Expect(second.Count).To(Equal(15))
+			Expect(second.Version).To(BeNumerically("==", 3))
+
+			p := persisted()
+			Expect(p.Count).To(Equal(15))
+			Expect(p.Version).To(BeNumerically("==", 3))
+		})
+	}
+
+	It("fails the write with the error returned by MergeFrom", func() {
+		Expect(db.Model(first).Update("label", "frozen").Error).To(Succeed())
+
+		second.Increment(3)
+		Expect(optimistic.Resolve(db, optimistic.Merge).Updates(second).Error).To(MatchError(errFrozen))
+
+		p := persisted()
+		Expect(p.Count).To(Equal(10))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	})
+
+	It("still rejects writes that conflict without a merge", func() {
+		first.Increment(2)
+		Expect(db.Updates(first).Error).To(Succeed())
+
+		second.Increment(3)
+		Expect(db.Updates(second).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Count).To(Equal(12))
+	})
+
+	It("rejects conflicting writes to models that cannot be merged", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		a, b := &TestModel{}, &TestModel{}
+		Expect(db.First(a, TestID).Error).To(Succeed())
+		Expect(db.First(b, TestID).Error).To(Succeed())
+
+		a.Value = 200
+		Expect(db.Updates(a).Error).To(Succeed())
+
+		b.Value = 300
+		Expect(optimistic.Resolve(db, optimistic.Merge).Updates(b).Error).
+			To(MatchError(optimistic.ErrConcurrentModification))
+	})
+})
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type UndeletableModel struct {
+	ID uint
+	optimistic.Versioned
+}
+
+var _ = Describe("Restore", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var deleted *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &UndeletableModel{})).To(Succeed())
+
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(m).Error).To(Succeed())
+		Expect(db.Delete(m).Error).To(Succeed())
+
+		deleted = &TestModel{}
+		Expect(db.Unscoped().First(deleted, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("restores the model, incrementing the version", func() {
+		Expect(optimistic.Restore(db, deleted)).To(Succeed())
+		Expect(deleted.Version).To(BeNumerically("==", 3))
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.DeletedAt.Valid).To(BeFalse())
+		Expect(persisted.Version).To(BeNumerically("==", 3))
+	})
+
+	It("detects concurrent modification", func() {
+		other := &TestModel{}
+		Expect(db.Unscoped().First(other, TestID).Error).To(Succeed())
+		Expect(optimistic.Restore(db, other)).To(Succeed())
+
+		Expect(optimistic.Restore(db, deleted)).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+
+	It("rejects models that cannot be soft deleted", func() {
+		Expect(optimistic.Restore(db, &UndeletableModel{ID: TestID})).To(MatchError(optimistic.ErrNotSoftDeletable))
+	})
+})
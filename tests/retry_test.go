@@ -0,0 +1,414 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("RunWithRetry", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("retries after a concurrent modification and then succeeds", func() {
+		attempts := 0
+		Expect(optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+			attempts++
+
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+
+			if attempts == 1 {
+				// simulate another writer getting in between our read and our write
+				other := &TestModel{}
+				Expect(tx.First(other, TestID).Error).To(Succeed())
+				other.Value = 200
+				Expect(tx.Updates(other).Error).To(Succeed())
+			}
+
+			m.Value += 1
+			return tx.Updates(m).Error
+		})).To(Succeed())
+
+		Expect(attempts).To(Equal(2))
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(m.Value).To(Equal(101))
+		Expect(m.Version).To(BeNumerically("==", 2))
+	})
+
+	It("stops retrying once the row has been deleted", func() {
+		attempts := 0
+		err := optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+			attempts++
+
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+
+			// simulate another writer deleting the row in between our read and our write
+			other := &TestModel{}
+			Expect(tx.First(other, TestID).Error).To(Succeed())
+			Expect(tx.Unscoped().Delete(other).Error).To(Succeed())
+
+			m.Value += 1
+			return tx.Updates(m).Error
+		})
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+		Expect(optimistic.IsConflict(err)).To(BeFalse())
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("returns nil immediately on success", func() {
+		attempts := 0
+		Expect(optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+			attempts++
+			return nil
+		})).To(Succeed())
+
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("does not retry other errors", func() {
+		otherErr := errors.New("some other failure")
+
+		attempts := 0
+		err := optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+			attempts++
+			return otherErr
+		})
+
+		Expect(err).To(Equal(otherErr))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("gives up after the maximum number of attempts", func() {
+		attempts := 0
+		err := optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+			attempts++
+			return optimistic.ErrConcurrentModification
+		})
+
+		Expect(attempts).To(Equal(3))
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+		var exhausted *optimistic.RetriesExhaustedError
+		Expect(errors.As(err, &exhausted)).To(BeTrue())
+		Expect(exhausted.Attempts).To(Equal(3))
+	})
+
+	When("retrying other errors", func() {
+		busyErr := errors.New("database is locked")
+		var opts optimistic.RetryOptions
+
+		BeforeEach(func() {
+			opts = optimistic.RetryOptions{
+				MaxAttempts: 4,
+				RetryIf: func(err error) bool {
+					return errors.Is(err, busyErr)
+				},
+			}
+		})
+
+		It("retries both conflicts and the errors chosen", func() {
+			failures := []error{optimistic.ErrConcurrentModification, busyErr, optimistic.ErrConcurrentModification}
+
+			attempts := 0
+			Expect(optimistic.RunWithRetryOpts(db, opts, func(tx *gorm.DB) error {
+				attempts++
+				if attempts <= len(failures) {
+					return failures[attempts-1]
+				}
+				return nil
+			})).To(Succeed())
+			Expect(attempts).To(Equal(4))
+		})
+
+		It("does not retry errors that are not chosen", func() {
+			otherErr := errors.New("some other failure")
+
+			attempts := 0
+			err := optimistic.RunWithRetryOpts(db, opts, func(tx *gorm.DB) error {
+				attempts++
+				return otherErr
+			})
+			Expect(err).To(Equal(otherErr))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("gives up after the maximum number of attempts", func() {
+			attempts := 0
+			err := optimistic.RunWithRetryOpts(db, opts, func(tx *gorm.DB) error {
+				attempts++
+				return busyErr
+			})
+			Expect(attempts).To(Equal(4))
+			Expect(err).To(MatchError(busyErr))
+
+			var exhausted *optimistic.RetriesExhaustedError
+			Expect(errors.As(err, &exhausted)).To(BeTrue())
+			Expect(exhausted.Attempts).To(Equal(4))
+		})
+
+		It("does not count the errors chosen as conflicts", func() {
+			stats, err := optimistic.RunWithRetryStats(db, opts, func(tx *gorm.DB) error {
+				return busyErr
+			})
+			Expect(err).To(MatchError(busyErr))
+			Expect(stats.Attempts).To(Equal(4))
+			Expect(stats.Conflicts).To(BeZero())
+		})
+	})
+
+	When("backing off between attempts", func() {
+		It("waits longer after each conflict", func() {
+			opts := optimistic.RetryOptions{
+				MaxAttempts: 4,
+				BaseDelay:   10 * time.Millisecond,
+			}
+
+			var attemptTimes []time.Time
+			err := optimistic.RunWithRetryOpts(db, opts, func(tx *gorm.DB) error {
+				attemptTimes = append(attemptTimes, time.Now())
+				return optimistic.ErrConcurrentModification
+			})
+			Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(attemptTimes).To(HaveLen(4))
+
+			for i := 1; i < len(attemptTimes); i++ {
+				observed := attemptTimes[i].Sub(attemptTimes[i-1])
+				Expect(observed).To(BeNumerically(">=", opts.Delay(i-1)))
+			}
+			Expect(opts.Delay(0)).To(Equal(10 * time.Millisecond))
+			Expect(opts.Delay(1)).To(Equal(20 * time.Millisecond))
+			Expect(opts.Delay(2)).To(Equal(40 * time.Millisecond))
+		})
+
+		It("caps the delay at the maximum", func() {
+			opts := optimistic.RetryOptions{
+				BaseDelay: 10 * time.Millisecond,
+				MaxDelay:  25 * time.Millisecond,
+			}
+
+			Expect(opts.Delay(1)).To(Equal(20 * time.Millisecond))
+			Expect(opts.Delay(2)).To(Equal(25 * time.Millisecond))
+			Expect(opts.Delay(100)).To(Equal(25 * time.Millisecond))
+		})
+
+		It("keeps jittered delays within bounds", func() {
+			opts := optimistic.RetryOptions{
+				BaseDelay: 100 * time.Millisecond,
+				Jitter:    0.5,
+			}
+
+			for i := 0; i < 100; i++ {
+				Expect(opts.Delay(0)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+			}
+		})
+
+		It("takes jitter from the given source", func() {
+			opts := optimistic.RetryOptions{
+				BaseDelay: 100 * time.Millisecond,
+				Jitter:    0.5,
+				Rand:      rand.New(rand.NewSource(42)),
+			}
+
+			Expect(opts.Delay(0)).To(Equal(87302837 * time.Nanosecond))
+			Expect(opts.Delay(1)).To(Equal(113200100 * time.Nanosecond))
+			Expect(opts.Delay(2)).To(Equal(441637540 * time.Nanosecond))
+
+			// the same seed gives the same delays again
+			opts.Rand = rand.New(rand.NewSource(42))
+			Expect(opts.Delay(0)).To(Equal(87302837 * time.Nanosecond))
+		})
+
+		It("stops waiting when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			opts := optimistic.RetryOptions{
+				MaxAttempts: 3,
+				BaseDelay:   time.Hour,
+			}
+
+			attempts := 0
+			err := optimistic.RunWithRetryOpts(db.WithContext(ctx), opts, func(tx *gorm.DB) error {
+				attempts++
+				cancel()
+				return optimistic.ErrConcurrentModification
+			})
+
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("gives up when the context deadline passes", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			opts := optimistic.RetryOptions{
+				MaxAttempts: 3,
+				BaseDelay:   time.Hour,
+			}
+
+			err := optimistic.RunWithRetryOpts(db.WithContext(ctx), opts, func(tx *gorm.DB) error {
+				return optimistic.ErrConcurrentModification
+			})
+
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+	})
+})
+
+var _ = Describe("Retrying", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("retries the transaction after a concurrent modification", func() {
+		attempts := 0
+		Expect(optimistic.Retrying(db, 3).Transaction(func(tx *gorm.DB) error {
+			attempts++
+
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+
+			if attempts == 1 {
+				other := &TestModel{}
+				Expect(tx.First(other, TestID).Error).To(Succeed())
+				other.Value = 200
+				Expect(tx.Updates(other).Error).To(Succeed())
+			}
+
+			m.Value += 1
+			return tx.Updates(m).Error
+		})).To(Succeed())
+
+		Expect(attempts).To(Equal(2))
+
+		m := &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		Expect(m.Value).To(Equal(101))
+	})
+
+	It("gives up after the maximum number of attempts", func() {
+		attempts := 0
+		err := optimistic.Retrying(db, 2).Transaction(func(tx *gorm.DB) error {
+			attempts++
+			return optimistic.ErrConcurrentModification
+		})
+
+		Expect(attempts).To(Equal(2))
+		var exhausted *optimistic.RetriesExhaustedError
+		Expect(errors.As(err, &exhausted)).To(BeTrue())
+		Expect(exhausted.Attempts).To(Equal(2))
+	})
+
+	It("waits between attempts as configured by the plugin", func() {
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{
+			Retry: optimistic.RetryOptions{BaseDelay: 20 * time.Millisecond},
+		}))).To(Succeed())
+
+		start := time.Now()
+		err := optimistic.Retrying(db, 3).Transaction(func(tx *gorm.DB) error {
+			return optimistic.ErrConcurrentModification
+		})
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(time.Since(start)).To(BeNumerically(">=", 60*time.Millisecond))
+	})
+})
+
+var _ = Describe("RunWithRetryStats", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("reports the attempts and conflicts of a run that eventually succeeds", func() {
+		attempts := 0
+		stats, err := optimistic.RunWithRetryStats(db, optimistic.RetryOptions{MaxAttempts: 5}, func(tx *gorm.DB) error {
+			attempts++
+
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+
+			if attempts <= 2 {
+				other := &TestModel{}
+				Expect(tx.First(other, TestID).Error).To(Succeed())
+				other.Value += 100
+				Expect(tx.Updates(other).Error).To(Succeed())
+			}
+
+			m.Value += 1
+			return tx.Updates(m).Error
+		})
+
+		Expect(err).To(Succeed())
+		Expect(stats).To(Equal(optimistic.RetryStats{Attempts: 3, Conflicts: 2}))
+	})
+
+	It("counts conflicts that did not fail an attempt", func() {
+		stats, err := optimistic.RunWithRetryStats(db, optimistic.RetryOptions{MaxAttempts: 5}, func(tx *gorm.DB) error {
+			stale := &TestModel{}
+			Expect(tx.First(stale, TestID).Error).To(Succeed())
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+			m.Value = 200
+			Expect(tx.Updates(m).Error).To(Succeed())
+
+			stale.Value = 300
+			Expect(tx.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			return nil
+		})
+
+		Expect(err).To(Succeed())
+		Expect(stats).To(Equal(optimistic.RetryStats{Attempts: 1, Conflicts: 1}))
+	})
+
+	It("reports every attempt when retries are exhausted", func() {
+		stats, err := optimistic.RunWithRetryStats(db, optimistic.RetryOptions{MaxAttempts: 3}, func(tx *gorm.DB) error {
+			return optimistic.ErrConcurrentModification
+		})
+
+		var exhausted *optimistic.RetriesExhaustedError
+		Expect(errors.As(err, &exhausted)).To(BeTrue())
+		Expect(stats).To(Equal(optimistic.RetryStats{Attempts: 3, Conflicts: 3}))
+	})
+})
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Save", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	When("the model is new", func() {
+		It("inserts it at the initial version", func() {
+			m := &TestModel{Value: 100}
+			Expect(db.Save(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 1))
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, m.ID).Error).To(Succeed())
+			Expect(persisted.Version).To(BeNumerically("==", 1))
+			Expect(persisted.Value).To(Equal(100))
+		})
+	})
+
+	When("the model is new but has a primary key", func() {
+		It("inserts it at the initial version", func() {
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Save(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 1))
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Version).To(BeNumerically("==", 1))
+		})
+
+		It("explains that the model must be read first if its row already exists", func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+			Expect(db.Save(m).Error).To(MatchError(optimistic.ErrUnknownReadVersion))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(100))
+			Expect(persisted.Version).To(BeNumerically("==", 1))
+		})
+
+		It("explains that the model must be read first if its row already exists with the plugin installed", func() {
+			Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+			Expect(db.Save(m).Error).To(MatchError(optimistic.ErrUnknownReadVersion))
+		})
+	})
+
+	When("the model already exists", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		It("increments the version exactly once", func() {
+			m.Value = 200
+			Expect(db.Save(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 2))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Value).To(Equal(200))
+		})
+
+		It("detects concurrent modification", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Save(other).Error).To(Succeed())
+
+			m.Value = 200
+			Expect(db.Save(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.Value).To(Equal(300))
+		})
+	})
+})
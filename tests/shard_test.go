@@ -0,0 +1,109 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Models shared between databases", func() {
+	var shardA, shardB *gorm.DB
+	var closeA, closeB func()
+
+	describeShards := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			shardA, closeA = openTestDB()
+			shardB, closeB = openTestDB()
+			for _, shard := range []*gorm.DB{shardA, shardB} {
+				if usePlugin {
+					Expect(shard.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+				}
+				Expect(shard.AutoMigrate(&TestModel{})).To(Succeed())
+				Expect(shard.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+			}
+
+			// move shard B's row on to a different version, so a guard using the wrong shard's version would be noticed
+			for i := 0; i < 2; i++ {
+				m := &TestModel{}
+				Expect(shardB.First(m, TestID).Error).To(Succeed())
+				m.Value++
+				Expect(shardB.Updates(m).Error).To(Succeed())
+			}
+		})
+
+		JustAfterEach(func() {
+			closeA()
+			closeB()
+		})
+
+		persisted := func(shard *gorm.DB) *TestModel {
+			p := &TestModel{}
+			Expect(shard.First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("guards each model on the version of the shard it was loaded from", func() {
+			a := &TestModel{}
+			Expect(shardA.First(a, TestID).Error).To(Succeed())
+			Expect(a.ReadVersion()).To(BeNumerically("==", 1))
+			b := &TestModel{}
+			Expect(shardB.First(b, TestID).Error).To(Succeed())
+			Expect(b.ReadVersion()).To(BeNumerically("==", 3))
+
+			a.Value = 200
+			Expect(shardA.Updates(a).Error).To(Succeed())
+			b.Value = 300
+			Expect(shardB.Updates(b).Error).To(Succeed())
+
+			Expect(persisted(shardA).Value).To(Equal(200))
+			Expect(persisted(shardA).Version).To(BeNumerically("==", 2))
+			Expect(persisted(shardB).Value).To(Equal(300))
+			Expect(persisted(shardB).Version).To(BeNumerically("==", 4))
+		})
+
+		It("is unaffected by writes made to the same row on another shard", func() {
+			a := &TestModel{}
+			Expect(shardA.First(a, TestID).Error).To(Succeed())
+
+			b := &TestModel{}
+			Expect(shardB.First(b, TestID).Error).To(Succeed())
+			b.Value = 300
+			Expect(shardB.Updates(b).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(shardA.Updates(a).Error).To(Succeed())
+			Expect(persisted(shardA).Version).To(BeNumerically("==", 2))
+		})
+
+		It("detects concurrent modification on the model's own shard", func() {
+			a := &TestModel{}
+			Expect(shardA.First(a, TestID).Error).To(Succeed())
+
+			other := &TestModel{}
+			Expect(shardA.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(shardA.Updates(other).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(shardA.Updates(a).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+
+		It("carries its read version to whichever shard it is written to", func() {
+			a := &TestModel{}
+			Expect(shardA.First(a, TestID).Error).To(Succeed())
+
+			// the model was read at version 1, which shard B's row has moved on from
+			a.Value = 200
+			Expect(shardB.Updates(a).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(persisted(shardB).Value).To(Equal(102))
+		})
+	}
+
+	describeShards(false)
+
+	When("using the plugin", func() {
+		describeShards(true)
+	})
+})
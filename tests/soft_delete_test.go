@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// DeletedFlag soft deletes rows by setting an integer flag, in the same way as the flag mode of
+// gorm.io/plugin/soft_delete, rather than recording the time they were deleted as gorm.DeletedAt does
+type DeletedFlag uint
+
+func (f *DeletedFlag) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		*f = DeletedFlag(v)
+	case nil:
+		*f = 0
+	}
+	return nil
+}
+
+func (f DeletedFlag) Value() (driver.Value, error) {
+	return int64(f), nil
+}
+
+func (DeletedFlag) QueryClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{deletedFlagClause{field: f}}
+}
+
+func (DeletedFlag) DeleteClauses(f *schema.Field) []clause.Interface {
+	return []clause.Interface{deletedFlagDeleteClause{field: f}}
+}
+
+type deletedFlagClause struct {
+	field *schema.Field
+}
+
+func (c deletedFlagClause) Name() string               { return "" }
+func (c deletedFlagClause) Build(clause.Builder)       {}
+func (c deletedFlagClause) MergeClause(*clause.Clause) {}
+
+func (c deletedFlagClause) ModifyStatement(stmt *gorm.Statement) {
+	if _, ok := stmt.Clauses["deleted_flag_enabled"]; !ok && !stmt.Unscoped {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: c.field.DBName}, Value: 0},
+		}})
+		stmt.Clauses["deleted_flag_enabled"] = clause.Clause{}
+	}
+}
+
+type deletedFlagDeleteClause struct {
+	field *schema.Field
+}
+
+func (c deletedFlagDeleteClause) Name() string               { return "" }
+func (c deletedFlagDeleteClause) Build(clause.Builder)       {}
+func (c deletedFlagDeleteClause) MergeClause(*clause.Clause) {}
+
+func (c deletedFlagDeleteClause) ModifyStatement(stmt *gorm.Statement) {
+	if stmt.SQL.String() != "" || stmt.Unscoped {
+		return
+	}
+
+	stmt.AddClause(clause.Set{{Column: clause.Column{Name: c.field.DBName}, Value: 1}})
+	stmt.SetColumn(c.field.DBName, 1, true)
+
+	_, queryValues := schema.GetIdentityFieldValuesMap(stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+	if len(values) > 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+	}
+
+	deletedFlagClause(c).ModifyStatement(stmt)
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.Build("UPDATE", "SET", "WHERE")
+}
+
+// FlaggedModel is soft deleted using a DeletedFlag rather than gorm.DeletedAt
+type FlaggedModel struct {
+	ID uint
+	optimistic.Versioned
+
+	Value     int
+	IsDeleted DeletedFlag
+}
+
+var _ = Describe("Custom soft delete types", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m, stale *FlaggedModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&FlaggedModel{})).To(Succeed())
+
+		Expect(db.Create(&FlaggedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+
+		m = &FlaggedModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &FlaggedModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *FlaggedModel {
+		p := &FlaggedModel{}
+		Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("increments the version when soft deleting", func() {
+		Expect(db.Delete(m).Error).To(Succeed())
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		p := persisted()
+		Expect(p.IsDeleted).To(BeEquivalentTo(1))
+		Expect(p.Version).To(BeNumerically("==", 2))
+		Expect(db.First(&FlaggedModel{}, TestID).Error).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("detects concurrent modification when soft deleting", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+
+		err := db.Delete(stale).Error
+		Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+		conflict := &optimistic.ConflictError{}
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.Operation).To(Equal(optimistic.OperationSoftDelete))
+
+		p := persisted()
+		Expect(p.IsDeleted).To(BeEquivalentTo(0))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	})
+
+	It("does not increment the version when hard deleting", func() {
+		Expect(db.Unscoped().Delete(m).Error).To(Succeed())
+		Expect(db.Unscoped().First(&FlaggedModel{}, TestID).Error).To(MatchError(gorm.ErrRecordNotFound))
+	})
+})
+
+// FlaggedHashedModel is locked by a hash of its content and soft deleted using a DeletedFlag
+type FlaggedHashedModel struct {
+	ID uint
+	optimistic.Hashed
+
+	Value     int
+	IsDeleted DeletedFlag
+}
+
+var _ = Describe("Custom soft delete types with Hashed", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&FlaggedHashedModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("leaves the soft delete column out of the hash", func() {
+		live := &FlaggedHashedModel{ID: TestID, Value: 100}
+		Expect(db.Create(live).Error).To(Succeed())
+		deleted := &FlaggedHashedModel{ID: TestID + 1, Value: 100, IsDeleted: 1}
+		Expect(db.Create(deleted).Error).To(Succeed())
+
+		Expect(deleted.ContentHash).To(Equal(live.ContentHash))
+	})
+})
+
+var _ = Describe("Soft deletes of a single model", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var counter *statementCounter
+	var m *TestModel
+
+	describeSingleStatement := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+
+			counter = newStatementCounter()
+			db = db.Session(&gorm.Session{Logger: counter})
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		It("writes the new version in the soft delete itself", func() {
+			Expect(db.Delete(m).Error).To(Succeed())
+			Expect(counter.Count()).To(BeNumerically("==", 1))
+			Expect(m.Version).To(BeNumerically("==", 2))
+
+			p := &TestModel{}
+			Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		It("still follows soft deletes of slices with an update of each version", func() {
+			Expect(db.Delete(&[]*TestModel{m}).Error).To(Succeed())
+			Expect(counter.Count()).To(BeNumerically("==", 2))
+
+			p := &TestModel{}
+			Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+	}
+
+	describeSingleStatement(false)
+
+	When("using the plugin", func() {
+		describeSingleStatement(true)
+	})
+})
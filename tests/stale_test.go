@@ -0,0 +1,72 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("IsStale", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("reports a model that has not been modified as fresh", func() {
+		m.Value = 200
+		Expect(optimistic.IsStale(db, m)).To(BeFalse())
+	})
+
+	It("reports a model that has been modified concurrently as stale", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		Expect(optimistic.IsStale(db, m)).To(BeTrue())
+
+		Expect(optimistic.Reload(db, m)).To(Succeed())
+		Expect(optimistic.IsStale(db, m)).To(BeFalse())
+	})
+
+	It("does not modify the model", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+
+		Expect(optimistic.IsStale(db, m)).To(BeTrue())
+		Expect(m.Value).To(Equal(100))
+		Expect(m.Version).To(BeNumerically("==", 1))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+	})
+
+	It("returns ErrRecordNotFound if the row has been deleted", func() {
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		Expect(db.Unscoped().Delete(other).Error).To(Succeed())
+
+		_, err := optimistic.IsStale(db, m)
+		Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+	})
+
+	It("requires a locked model", func() {
+		_, err := optimistic.IsStale(db, &UnlockedModel{ID: TestID})
+		Expect(err).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
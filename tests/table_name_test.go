@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Writing models to tables named by the statement", func() {
+	const table = "events_2024"
+
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeTableName := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+			// migrating the named table would try to create indexes named after the model's own table, which SQLite
+			// does not allow to be shared between tables
+			Expect(db.Exec("CREATE TABLE " + table + " (id integer PRIMARY KEY, created_at datetime, updated_at datetime, " +
+				"deleted_at datetime, version integer NOT NULL DEFAULT 1, value integer)").Error).To(Succeed())
+
+			// the model's own table holds a row with the same primary key, which writes to the named table must not
+			// touch
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+			Expect(db.Table(table).Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.Table(table).First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func(table string) *TestModel {
+			p := &TestModel{}
+			Expect(db.Table(table).Unscoped().First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("guards and moves on the version in the named table", func() {
+			m.Value = 200
+			Expect(db.Table(table).Updates(m).Error).To(Succeed())
+
+			p := persisted(table)
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 2))
+			Expect(persisted("test_models").Version).To(BeNumerically("==", 1))
+		})
+
+		It("detects concurrent modification of the row in the named table", func() {
+			other := &TestModel{}
+			Expect(db.Table(table).First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Table(table).Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			err := db.Table(table).Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+			var conflict *optimistic.ConflictError
+			Expect(errors.As(err, &conflict)).To(BeTrue())
+			Expect(conflict.Table).To(Equal(table))
+		})
+
+		It("finds the row in the named table missing, even if the model's own table still holds it", func() {
+			Expect(db.Exec("DELETE FROM " + table).Error).To(Succeed())
+
+			m.Value = 200
+			err := db.Table(table).Updates(m).Error
+
+			var missing *optimistic.MissingRowError
+			Expect(errors.As(err, &missing)).To(BeTrue())
+			Expect(missing.Table).To(Equal(table))
+		})
+
+		It("overwrites the row in the named table when resolving a conflict", func() {
+			other := &TestModel{}
+			Expect(db.Table(table).First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Table(table).Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			Expect(optimistic.Resolve(db.Table(table), optimistic.LastWriteWins).Updates(m).Error).To(Succeed())
+
+			p := persisted(table)
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 3))
+			Expect(persisted("test_models").Value).To(Equal(100))
+		})
+
+		It("moves on the version in the named table when soft deleting a model", func() {
+			Expect(db.Table(table).Delete(m).Error).To(Succeed())
+
+			p := persisted(table)
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 2))
+			Expect(persisted("test_models").Version).To(BeNumerically("==", 1))
+		})
+
+		It("moves on the versions in the named table when soft deleting a slice", func() {
+			Expect(db.Table(table).Delete(&[]*TestModel{m}).Error).To(Succeed())
+
+			p := persisted(table)
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 2))
+			Expect(persisted("test_models").Version).To(BeNumerically("==", 1))
+		})
+
+		When("the row in the named table is modified concurrently", func() {
+			JustBeforeEach(func() {
+				other := &TestModel{}
+				Expect(db.Table(table).First(other, TestID).Error).To(Succeed())
+				other.Value = 300
+				Expect(db.Table(table).Updates(other).Error).To(Succeed())
+			})
+
+			It("reports the model as stale", func() {
+				stale, err := optimistic.IsStale(db.Table(table), m)
+				Expect(err).To(Succeed())
+				Expect(stale).To(BeTrue())
+			})
+
+			It("returns the latest model from the named table with the conflict", func() {
+				m.Value = 200
+				latest, err := optimistic.UpdateReturningLatest(db.Table(table), m)
+				Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+				Expect(latest).NotTo(BeNil())
+				Expect(latest.Value).To(Equal(300))
+				Expect(latest.Version).To(BeNumerically("==", 2))
+			})
+
+			It("diffs the model against the row in the named table", func() {
+				diffs, err := optimistic.Diff(db.Table(table), m)
+				Expect(err).To(Succeed())
+				Expect(diffs).To(HaveKey("Value"))
+				Expect(diffs["Value"].Remote).To(Equal(300))
+				Expect(diffs).To(HaveKey("Version"))
+			})
+		})
+
+		It("bulk updates the rows of the named table", func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+			Expect(db.Table(table).Create(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 100}).Error).To(Succeed())
+
+			var models []*TestModel
+			Expect(db.Table(table).Order("id").Find(&models).Error).To(Succeed())
+
+			other := &TestModel{}
+			Expect(db.Table(table).First(other, TestID+1).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Table(table).Updates(other).Error).To(Succeed())
+
+			for _, model := range models {
+				model.Value = 200
+			}
+			failures, err := optimistic.BulkUpdate(db.Table(table), models)
+			Expect(err).To(Succeed())
+			Expect(failures).To(HaveLen(1))
+
+			var conflict *optimistic.ConflictError
+			Expect(errors.As(failures[0], &conflict)).To(BeTrue())
+			Expect(conflict.Table).To(Equal(table))
+			Expect(conflict.PrimaryKey).To(BeNumerically("==", TestID+1))
+
+			p := persisted(table)
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 2))
+			Expect(persisted("test_models").Value).To(Equal(100))
+			Expect(persisted("test_models").Version).To(BeNumerically("==", 1))
+		})
+	}
+
+	describeTableName(false)
+
+	When("using the plugin", func() {
+		describeTableName(true)
+	})
+})
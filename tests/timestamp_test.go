@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type TimestampModel struct {
+	gorm.Model
+	optimistic.TimestampVersioned
+
+	Value int
+}
+
+var _ = Describe("TimestampVersioned", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TimestampModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	When("an entry is created", func() {
+		var m *TimestampModel
+
+		JustBeforeEach(func() {
+			m = &TimestampModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+		})
+
+		It("has an initial timestamp in memory", func() {
+			Expect(m.UpdatedVersion.IsZero()).To(BeFalse())
+			Expect(m.ReadTimestamp()).To(Equal(m.UpdatedVersion))
+		})
+
+		It("has the same initial timestamp in the database", func() {
+			persisted := &TimestampModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.UpdatedVersion.Equal(m.UpdatedVersion)).To(BeTrue())
+		})
+
+		When("the entry is updated", func() {
+			var created *TimestampModel
+
+			JustBeforeEach(func() {
+				created = &TimestampModel{}
+				*created = *m
+
+				m.Value = 1000
+				Expect(db.Updates(m).Error).To(Succeed())
+			})
+
+			It("has a later timestamp in memory", func() {
+				Expect(m.UpdatedVersion.After(created.UpdatedVersion)).To(BeTrue())
+			})
+
+			It("has persisted the later timestamp", func() {
+				persisted := &TimestampModel{}
+				Expect(db.First(persisted, TestID).Error).To(Succeed())
+				Expect(persisted.UpdatedVersion.Equal(m.UpdatedVersion)).To(BeTrue())
+			})
+		})
+
+		When("the entry is (soft) deleted", func() {
+			var created *TimestampModel
+
+			JustBeforeEach(func() {
+				created = &TimestampModel{}
+				*created = *m
+
+				Expect(db.Delete(m).Error).To(Succeed())
+			})
+
+			It("has persisted a later timestamp", func() {
+				persisted := &TimestampModel{}
+				Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+				Expect(persisted.UpdatedVersion.After(created.UpdatedVersion)).To(BeTrue())
+			})
+		})
+
+		It("does not interfere with 'hard' deletion", func() {
+			Expect(db.Unscoped().Delete(m).Error).To(Succeed())
+		})
+
+		It("does not conflict on rapid sequential updates", func() {
+			previous := m.UpdatedVersion
+			for i := 0; i < 20; i++ {
+				current := &TimestampModel{}
+				Expect(db.First(current, TestID).Error).To(Succeed())
+
+				current.Value = i
+				Expect(db.Updates(current).Error).To(Succeed())
+				Expect(current.UpdatedVersion.After(previous)).To(BeTrue())
+				previous = current.UpdatedVersion
+			}
+		})
+
+		When("there are concurrent modifications", func() {
+			updating := func(model *TimestampModel, tx *gorm.DB) error {
+				model.Value += 100
+				return tx.Updates(model).Error
+			}
+			softDeletion := func(model *TimestampModel, tx *gorm.DB) error {
+				return tx.Delete(model).Error
+			}
+			hardDeletion := func(model *TimestampModel, tx *gorm.DB) error {
+				return tx.Unscoped().Delete(model).Error
+			}
+
+			modifications := map[string]func(model *TimestampModel, tx *gorm.DB) error{
+				"updating":      updating,
+				"soft-deletion": softDeletion,
+				"hard-deletion": hardDeletion,
+			}
+
+			for aName, aModification := range modifications {
+				aName, aModification := aName, aModification
+				for bName, bModification := range modifications {
+					bName, bModification := bName, bModification
+					It(fmt.Sprintf("detects [%s vs %s]", aName, bName), func() {
+						a := &TimestampModel{}
+						b := &TimestampModel{}
+
+						Expect(db.First(a, TestID).Error).To(Succeed())
+						Expect(db.First(b, TestID).Error).To(Succeed())
+
+						Expect(db.Transaction(func(tx *gorm.DB) error {
+							return aModification(a, tx)
+						})).To(Succeed())
+
+						// deleting the model leaves nothing to conflict with, unless the row is still there to hard
+						// delete
+						expected := optimistic.ErrConcurrentModification
+						if aName == "hard-deletion" || (aName == "soft-deletion" && bName != "hard-deletion") {
+							expected = gorm.ErrRecordNotFound
+						}
+						Expect(db.Transaction(func(tx *gorm.DB) error {
+							return bModification(b, tx)
+						})).To(MatchError(expected))
+					})
+				}
+			}
+		})
+	})
+})
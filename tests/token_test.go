@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Version tokens", func() {
+	DescribeTable("round trip", func(version uint64) {
+		m := &TestModel{Versioned: optimistic.Versioned{Version: version}}
+		token := m.MarshalVersionToken()
+		Expect(token).NotTo(BeEmpty())
+
+		parsed, err := optimistic.ParseVersionToken(token)
+		Expect(err).To(Succeed())
+		Expect(parsed).To(Equal(version))
+	},
+		Entry("zero", uint64(0)),
+		Entry("one", uint64(1)),
+		Entry("a multi-byte version", uint64(300)),
+		Entry("the largest version", uint64(math.MaxUint64)),
+	)
+
+	DescribeTable("rejects malformed tokens", func(token string) {
+		_, err := optimistic.ParseVersionToken(token)
+		Expect(err).To(MatchError(optimistic.ErrMalformedVersionToken))
+	},
+		Entry("empty", ""),
+		Entry("not base64", "not a token!"),
+		Entry("padded", "AQ=="),
+		Entry("truncated", "gA"),
+		Entry("trailing data", "AQE"),
+		Entry("non-minimal encoding", "gQA"),
+		Entry("too long", "_________________w"),
+	)
+
+	It("produces distinct tokens for distinct versions", func() {
+		seen := map[string]uint64{}
+		for version := uint64(0); version < 1000; version++ {
+			token := (&TestModel{Versioned: optimistic.Versioned{Version: version}}).MarshalVersionToken()
+			Expect(seen).NotTo(HaveKey(token))
+			seen[token] = version
+		}
+	})
+
+	It("exposes the version in JSON", func() {
+		body, err := json.Marshal(&TestModel{Model: gorm.Model{ID: TestID}, Versioned: optimistic.Versioned{Version: 3}})
+		Expect(err).To(Succeed())
+
+		var fields map[string]interface{}
+		Expect(json.Unmarshal(body, &fields)).To(Succeed())
+		Expect(fields).To(HaveKeyWithValue("version", BeNumerically("==", 3)))
+	})
+
+	Describe("restoring the read version", func() {
+		var db *gorm.DB
+		var closeDB func()
+
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		It("guards updates against the version in the token", func() {
+			m := &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			token := m.MarshalVersionToken()
+
+			version, err := optimistic.ParseVersionToken(token)
+			Expect(err).To(Succeed())
+			received := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+			received.SetReadVersion(version)
+			Expect(db.Updates(received).Error).To(Succeed())
+
+			stale := &TestModel{Model: gorm.Model{ID: TestID}, Value: 300}
+			stale.SetReadVersion(version)
+			Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+	})
+})
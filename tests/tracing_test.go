@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type parentSpanKey struct{}
+
+// fakeSpan records the attributes set on it and whether it was ended
+type fakeSpan struct {
+	name       string
+	parent     interface{}
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *fakeSpan) End() {
+	s.ended = true
+}
+
+// fakeTracer records the spans started with it, along with the value of parentSpanKey in the context they were started
+// with
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, optimistic.Span) {
+	span := &fakeSpan{name: name, parent: ctx.Value(parentSpanKey{}), attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, parentSpanKey{}, span), span
+}
+
+var _ = Describe("Tracing", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var tracer *fakeTracer
+	var m, stale *TestModel
+
+	BeforeEach(func() {
+		tracer = &fakeTracer{}
+
+		db, closeDB = openTestDB()
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{Tracer: tracer}))).To(Succeed())
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	AfterEach(func() {
+		closeDB()
+	})
+
+	It("does not trace reads and creates", func() {
+		Expect(tracer.spans).To(BeEmpty())
+	})
+
+	It("traces a successful update as a child of the statement's span", func() {
+		ctx := context.WithValue(context.Background(), parentSpanKey{}, "parent")
+		m.Value = 200
+		Expect(db.WithContext(ctx).Updates(m).Error).To(Succeed())
+
+		Expect(tracer.spans).To(HaveLen(1))
+		span := tracer.spans[0]
+		Expect(span.name).To(Equal("optimistic: update"))
+		Expect(span.parent).To(Equal("parent"))
+		Expect(span.ended).To(BeTrue())
+		Expect(span.attributes).To(Equal(map[string]interface{}{
+			optimistic.SpanAttributeTable:           "test_models",
+			optimistic.SpanAttributeOperation:       "update",
+			optimistic.SpanAttributeExpectedVersion: uint64(1),
+			optimistic.SpanAttributeConflict:        false,
+		}))
+	})
+
+	It("tags a conflicting update", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(tracer.spans).To(HaveLen(2))
+		span := tracer.spans[1]
+		Expect(span.ended).To(BeTrue())
+		Expect(span.attributes[optimistic.SpanAttributeExpectedVersion]).To(BeEquivalentTo(1))
+		Expect(span.attributes[optimistic.SpanAttributeConflict]).To(BeTrue())
+	})
+
+	It("traces deletes", func() {
+		m.Value = 200
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(db.Delete(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+		Expect(tracer.spans).To(HaveLen(2))
+		span := tracer.spans[1]
+		Expect(span.name).To(Equal("optimistic: soft delete"))
+		Expect(span.ended).To(BeTrue())
+		Expect(span.attributes[optimistic.SpanAttributeOperation]).To(Equal("soft delete"))
+		Expect(span.attributes[optimistic.SpanAttributeConflict]).To(BeTrue())
+	})
+})
@@ -0,0 +1,291 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Multiple models in one transaction", func() {
+	const aID, bID uint = 1, 2
+
+	var db *gorm.DB
+	var closeDB func()
+	var a, b *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		// give A and B different versions, so that a guard using the wrong model's read version would be noticed
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: aID}, Value: 100}).Error).To(Succeed())
+		for i := 0; i < 2; i++ {
+			m := &TestModel{}
+			Expect(db.First(m, aID).Error).To(Succeed())
+			m.Value += 1
+			Expect(db.Updates(m).Error).To(Succeed())
+		}
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: bID}, Value: 100}).Error).To(Succeed())
+
+		a = &TestModel{}
+		Expect(db.First(a, aID).Error).To(Succeed())
+		Expect(a.ReadVersion()).To(BeNumerically("==", 3))
+		b = &TestModel{}
+		Expect(db.First(b, bID).Error).To(Succeed())
+		Expect(b.ReadVersion()).To(BeNumerically("==", 1))
+
+		// make B stale
+		other := &TestModel{}
+		Expect(db.First(other, bID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	expectConflictOnB := func(err error) {
+		var conflict *optimistic.ConflictError
+		Expect(errors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.PrimaryKey).To(BeEquivalentTo(bID))
+		Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+	}
+
+	It("only fails the update of the stale model", func() {
+		var aErr, bErr error
+		err := db.Transaction(func(tx *gorm.DB) error {
+			a.Value = 300
+			aErr = tx.Updates(a).Error
+			b.Value = 300
+			bErr = tx.Updates(b).Error
+			return bErr
+		})
+
+		Expect(aErr).To(Succeed())
+		Expect(a.Version).To(BeNumerically("==", 4))
+		expectConflictOnB(bErr)
+		expectConflictOnB(err)
+	})
+
+	It("only fails the update of the stale model when it is updated first", func() {
+		var aErr, bErr error
+		Expect(db.Transaction(func(tx *gorm.DB) error {
+			b.Value = 300
+			bErr = tx.Updates(b).Error
+			a.Value = 300
+			aErr = tx.Updates(a).Error
+			return aErr
+		})).To(Succeed())
+
+		expectConflictOnB(bErr)
+		Expect(aErr).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, aID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+		Expect(persisted.Version).To(BeNumerically("==", 4))
+		persisted = &TestModel{}
+		Expect(db.First(persisted, bID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(200))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	Describe("optimistic.Transaction", func() {
+		persisted := func(id uint) *TestModel {
+			p := &TestModel{}
+			Expect(db.First(p, id).Error).To(Succeed())
+			return p
+		}
+
+		It("rolls back and returns the ConflictError in place of a bare sentinel", func() {
+			err := optimistic.Transaction(db, func(tx *gorm.DB) error {
+				a.Value = 300
+				if err := tx.Updates(a).Error; err != nil {
+					return err
+				}
+				b.Value = 300
+				if err := tx.Updates(b).Error; optimistic.IsConflict(err) {
+					return optimistic.ErrConcurrentModification
+				}
+				return nil
+			})
+
+			expectConflictOnB(err)
+			Expect(persisted(aID).Value).To(Equal(102))
+			Expect(persisted(aID).Version).To(BeNumerically("==", 3))
+		})
+
+		It("commits if the function succeeds", func() {
+			Expect(optimistic.Transaction(db, func(tx *gorm.DB) error {
+				a.Value = 300
+				return tx.Updates(a).Error
+			})).To(Succeed())
+
+			Expect(persisted(aID).Value).To(Equal(300))
+		})
+
+		It("returns other errors unchanged", func() {
+			errOther := errors.New("other")
+			Expect(optimistic.Transaction(db, func(tx *gorm.DB) error {
+				b.Value = 300
+				_ = tx.Updates(b).Error
+				return errOther
+			})).To(Equal(errOther))
+
+			Expect(optimistic.Transaction(db, func(tx *gorm.DB) error {
+				return optimistic.ErrConcurrentModification
+			})).To(Equal(optimistic.ErrConcurrentModification))
+		})
+	})
+})
+
+var _ = Describe("Soft deletes in a transaction", func() {
+	var db, other *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		// a separate database handle on the same file, so that reads use a different connection to the transaction
+		var err error
+		other, err = gorm.Open(sqlite.Open(db.Dialector.(*sqlite.Dialector).DSN), &gorm.Config{
+			Logger: db.Logger,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		otherDB, err := other.DB()
+		Expect(err).To(Succeed())
+		Expect(otherDB.Close()).To(Succeed())
+
+		closeDB()
+	})
+
+	persistedVersion := func() uint64 {
+		p := &TestModel{}
+		Expect(other.Unscoped().First(p, TestID).Error).To(Succeed())
+		return p.Version
+	}
+
+	It("bumps the version within the transaction, so it is only visible once committed", func() {
+		// delete on the only connection the database allows, so the version bump would block if it needed another
+		Expect(db.Transaction(func(tx *gorm.DB) error {
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+			Expect(tx.Delete(m).Error).To(Succeed())
+
+			inTx := &TestModel{}
+			Expect(tx.Unscoped().First(inTx, TestID).Error).To(Succeed())
+			Expect(inTx.Version).To(BeNumerically("==", 2))
+
+			Expect(persistedVersion()).To(BeNumerically("==", 1))
+			return nil
+		})).To(Succeed())
+
+		Expect(persistedVersion()).To(BeNumerically("==", 2))
+	})
+
+	It("does not bump the version if the transaction is rolled back", func() {
+		errRollback := errors.New("rollback")
+		Expect(db.Transaction(func(tx *gorm.DB) error {
+			m := &TestModel{}
+			Expect(tx.First(m, TestID).Error).To(Succeed())
+			Expect(tx.Delete(m).Error).To(Succeed())
+			return errRollback
+		})).To(Equal(errRollback))
+
+		Expect(persistedVersion()).To(BeNumerically("==", 1))
+	})
+})
+
+var _ = Describe("Nested transactions", func() {
+	const aID, bID uint = 1, 2
+
+	var db *gorm.DB
+	var closeDB func()
+	var a, b *TestModel
+
+	describeNested := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: aID}, Value: 100}).Error).To(Succeed())
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: bID}, Value: 100}).Error).To(Succeed())
+
+			a = &TestModel{}
+			Expect(db.First(a, aID).Error).To(Succeed())
+			b = &TestModel{}
+			Expect(db.First(b, bID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		It("detects a conflict in a nested transaction after the outer transaction wrote a row", func() {
+			other := &TestModel{}
+			Expect(db.First(other, bID).Error).To(Succeed())
+			other.Value = 200
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			var bErr error
+			Expect(db.Transaction(func(tx *gorm.DB) error {
+				a.Value = 300
+				Expect(tx.Updates(a).Error).To(Succeed())
+				Expect(tx.RowsAffected).To(BeNumerically("==", 0))
+
+				return tx.Transaction(func(nested *gorm.DB) error {
+					b.Value = 300
+					bErr = nested.Updates(b).Error
+					return nil
+				})
+			})).To(Succeed())
+
+			Expect(bErr).To(MatchError(optimistic.ErrConcurrentModification))
+			persisted := &TestModel{}
+			Expect(db.First(persisted, bID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(200))
+		})
+
+		It("does not report a conflict in a nested transaction after the outer transaction's write conflicted", func() {
+			other := &TestModel{}
+			Expect(db.First(other, aID).Error).To(Succeed())
+			other.Value = 200
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			Expect(db.Transaction(func(tx *gorm.DB) error {
+				a.Value = 300
+				Expect(tx.Updates(a).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+				return tx.Transaction(func(nested *gorm.DB) error {
+					b.Value = 300
+					return nested.Updates(b).Error
+				})
+			})).To(Succeed())
+
+			Expect(b.Version).To(BeNumerically("==", 2))
+			persisted := &TestModel{}
+			Expect(db.First(persisted, bID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+		})
+	}
+
+	describeNested(false)
+
+	When("using the plugin", func() {
+		describeNested(true)
+	})
+})
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+type UnlockedModel struct {
+	ID    uint
+	Value int
+}
+
+var _ = Describe("UpdateColumnVersioned", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m, stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &HashedModel{}, &UnlockedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("updates the column and increments the version", func() {
+		Expect(optimistic.UpdateColumnVersioned(db.Model(m), "value", 200)).To(Succeed())
+		Expect(m.Value).To(Equal(200))
+		Expect(m.Version).To(BeNumerically("==", 2))
+
+		p := persisted()
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Version).To(BeNumerically("==", 2))
+		Expect(p.UpdatedAt).To(Equal(m.UpdatedAt))
+	})
+
+	It("detects concurrent modification, unlike UpdateColumn", func() {
+		Expect(optimistic.UpdateColumnVersioned(db.Model(m), "value", 200)).To(Succeed())
+
+		Expect(optimistic.UpdateColumnVersioned(db.Model(stale), "value", 300)).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(200))
+
+		// plain UpdateColumn is not guarded, and does not move the version on
+		Expect(db.Model(stale).UpdateColumn("value", 300).Error).To(Succeed())
+		p := persisted()
+		Expect(p.Value).To(Equal(300))
+		Expect(p.Version).To(BeNumerically("==", 2))
+	})
+
+	It("respects the conditions of the statement", func() {
+		Expect(optimistic.UpdateColumnVersioned(db.Model(m).Where("value > ?", 1000), "value", 200)).
+			To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(persisted().Value).To(Equal(100))
+	})
+
+	It("works with other kinds of lock", func() {
+		h := &HashedModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(h).Error).To(Succeed())
+		hStale := &HashedModel{}
+		Expect(db.First(hStale, TestID).Error).To(Succeed())
+
+		Expect(optimistic.UpdateColumnVersioned(db.Model(h), "value", 200)).To(Succeed())
+		Expect(optimistic.UpdateColumnVersioned(db.Model(hStale), "value", 300)).
+			To(MatchError(optimistic.ErrConcurrentModification))
+
+		persistedHash := &HashedModel{}
+		Expect(db.First(persistedHash, TestID).Error).To(Succeed())
+		Expect(persistedHash.Value).To(Equal(200))
+		Expect(persistedHash.ContentHash).To(Equal(h.ContentHash))
+		Expect(persistedHash.ContentHash).NotTo(Equal(hStale.ContentHash))
+	})
+
+	It("rejects models without a lock", func() {
+		u := &UnlockedModel{ID: TestID}
+		Expect(db.Create(u).Error).To(Succeed())
+		Expect(optimistic.UpdateColumnVersioned(db.Model(u), "value", 200)).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
+
+var _ = Describe("AtomicAdd", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &UnlockedModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 0}).Error).To(Succeed())
+
+		m = &TestModel{}
+		Expect(db.First(m, TestID).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	It("adds to the column and moves the version on", func() {
+		Expect(optimistic.AtomicAdd(db, m, "value", 5)).To(Succeed())
+		Expect(m.Value).To(Equal(5))
+		Expect(m.Version).To(BeNumerically("==", 2))
+		Expect(persisted().Value).To(Equal(5))
+		Expect(persisted().Version).To(BeNumerically("==", 2))
+
+		Expect(optimistic.AtomicAdd(db, persisted(), "Value", -2)).To(Succeed())
+		Expect(persisted().Value).To(Equal(3))
+	})
+
+	It("rejects an addition to a stale model", func() {
+		stale := &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+		Expect(optimistic.AtomicAdd(db, m, "value", 5)).To(Succeed())
+
+		Expect(optimistic.AtomicAdd(db, stale, "value", 5)).To(MatchError(optimistic.ErrConcurrentModification))
+		Expect(stale.Value).To(Equal(0))
+		Expect(persisted().Value).To(Equal(5))
+	})
+
+	It("adds every concurrent addition when retried", func() {
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer GinkgoRecover()
+				errs <- optimistic.RunWithRetry(db, 3, func(tx *gorm.DB) error {
+					counter := &TestModel{}
+					if err := tx.First(counter, TestID).Error; err != nil {
+						return err
+					}
+					return optimistic.AtomicAdd(tx, counter, "value", 5)
+				})
+			}()
+		}
+		Expect(<-errs).To(Succeed())
+		Expect(<-errs).To(Succeed())
+
+		Expect(persisted().Value).To(Equal(10))
+		Expect(persisted().Version).To(BeNumerically("==", 3))
+	})
+
+	It("requires an integer column", func() {
+		Expect(optimistic.AtomicAdd(db, m, "created_at", 1)).To(MatchError(optimistic.ErrNotCounter))
+		Expect(optimistic.AtomicAdd(db, m, "missing", 1)).To(MatchError(optimistic.ErrNotCounter))
+	})
+
+	It("requires a locked model", func() {
+		Expect(db.Create(&UnlockedModel{ID: TestID}).Error).To(Succeed())
+		Expect(optimistic.AtomicAdd(db, &UnlockedModel{ID: TestID}, "value", 1)).To(MatchError(optimistic.ErrNotLocked))
+	})
+})
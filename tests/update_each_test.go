@@ -0,0 +1,108 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("UpdateEach", func() {
+	const count = 3
+
+	var db *gorm.DB
+	var closeDB func()
+	var models []*TestModel
+
+	describeUpdateEach := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+			models = nil
+			for id := uint(1); id <= count; id++ {
+				Expect(db.Create(&TestModel{Model: gorm.Model{ID: id}, Value: 100}).Error).To(Succeed())
+
+				m := &TestModel{}
+				Expect(db.First(m, id).Error).To(Succeed())
+				models = append(models, m)
+			}
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func(id uint) *TestModel {
+			p := &TestModel{}
+			Expect(db.First(p, id).Error).To(Succeed())
+			return p
+		}
+
+		It("updates every model", func() {
+			for i, m := range models {
+				m.Value = 200 + i
+			}
+
+			conflicts, err := optimistic.UpdateEach(db, models)
+			Expect(err).To(Succeed())
+			Expect(conflicts).To(BeEmpty())
+
+			for i, m := range models {
+				Expect(m.Version).To(BeNumerically("==", 2))
+				p := persisted(m.ID)
+				Expect(p.Value).To(Equal(200 + i))
+				Expect(p.Version).To(BeNumerically("==", 2))
+			}
+		})
+
+		It("reports the stale model by primary key and updates the rest", func() {
+			other := &TestModel{}
+			Expect(db.First(other, 2).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			for _, m := range models {
+				m.Value = 200
+			}
+
+			conflicts, err := optimistic.UpdateEach(db, models)
+			Expect(err).To(Succeed())
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts).To(HaveKey(uint(2)))
+			Expect(conflicts[uint(2)].ExpectedVersion).To(BeNumerically("==", 1))
+
+			Expect(persisted(1).Value).To(Equal(200))
+			Expect(persisted(2).Value).To(Equal(300))
+			Expect(persisted(3).Value).To(Equal(200))
+		})
+
+		It("rolls back every update if one fails with another error", func() {
+			Expect(db.Exec("DELETE FROM test_models WHERE id = ?", 2).Error).To(Succeed())
+
+			for _, m := range models {
+				m.Value = 200
+			}
+
+			conflicts, err := optimistic.UpdateEach(db, models)
+			Expect(err).To(MatchError(gorm.ErrRecordNotFound))
+			Expect(conflicts).To(BeNil())
+
+			Expect(models[0].Value).To(Equal(200))
+			Expect(models[0].Version).To(BeNumerically("==", 1))
+			Expect(models[0].ReadVersion()).To(BeNumerically("==", 1))
+			Expect(persisted(1).Value).To(Equal(100))
+			Expect(persisted(1).Version).To(BeNumerically("==", 1))
+		})
+	}
+
+	describeUpdateEach(false)
+
+	When("using the plugin", func() {
+		describeUpdateEach(true)
+	})
+})
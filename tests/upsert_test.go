@@ -0,0 +1,163 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Upserts", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{}, &RevisionedModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	persisted := func() *TestModel {
+		p := &TestModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		return p
+	}
+
+	idColumns := []clause.Column{{Name: "id"}}
+	upserts := map[string]clause.OnConflict{
+		"updating all columns":      {UpdateAll: true},
+		"updating selected columns": {Columns: idColumns, DoUpdates: clause.AssignmentColumns([]string{"value"})},
+		"assigning the version": {
+			Columns:   idColumns,
+			DoUpdates: clause.AssignmentColumns([]string{"value", "version"}),
+		},
+	}
+
+	for name, onConflict := range upserts {
+		onConflict := onConflict
+
+		It("increments the version of the existing row when "+name, func() {
+			first := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Clauses(onConflict).Create(first).Error).To(Succeed())
+			Expect(first.Version).To(BeNumerically("==", 1))
+			Expect(persisted().Version).To(BeNumerically("==", 1))
+
+			second := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+			Expect(db.Clauses(onConflict).Create(second).Error).To(Succeed())
+			Expect(second.Version).To(BeNumerically("==", 2))
+			Expect(second.ReadVersion()).To(BeNumerically("==", 2))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 2))
+
+			// the upserted model is at the version of its row, so can be updated without reloading it
+			second.Value = 300
+			Expect(db.Updates(second).Error).To(Succeed())
+			Expect(persisted().Version).To(BeNumerically("==", 3))
+		})
+	}
+
+	It("leaves the version alone when the conflict does nothing", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		Expect(db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 200}).Error).To(Succeed())
+
+		p := persisted()
+		Expect(p.Value).To(Equal(100))
+		Expect(p.Version).To(BeNumerically("==", 1))
+	})
+
+	It("reads the version of the existing row when the conflict does nothing", func() {
+		existing := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(existing).Error).To(Succeed())
+		existing.Value = 200
+		Expect(db.Updates(existing).Error).To(Succeed())
+
+		m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 300}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(m)
+		Expect(result.Error).To(Succeed())
+		Expect(result.RowsAffected).To(BeNumerically("==", 0))
+		Expect(m.Version).To(BeNumerically("==", 2))
+		Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+
+		Expect(db.Updates(m).Error).To(Succeed())
+		Expect(persisted().Value).To(Equal(300))
+		Expect(persisted().Version).To(BeNumerically("==", 3))
+	})
+
+	It("reads the version of each existing row when the conflict does nothing for a slice", func() {
+		existing := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+		Expect(db.Create(existing).Error).To(Succeed())
+		Expect(db.Updates(existing).Error).To(Succeed())
+
+		models := []TestModel{
+			{Model: gorm.Model{ID: TestID}, Value: 200},
+			{Model: gorm.Model{ID: TestID + 1}, Value: 200},
+		}
+		Expect(db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models).Error).To(Succeed())
+		Expect(models[0].ReadVersion()).To(BeNumerically("==", 2))
+		Expect(models[1].ReadVersion()).To(BeNumerically("==", 1))
+	})
+
+	Describe("CreateIfAbsent", func() {
+		It("reports that an absent model was created", func() {
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			inserted, err := optimistic.CreateIfAbsent(db, m)
+			Expect(err).To(Succeed())
+			Expect(inserted).To(BeTrue())
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+			Expect(persisted().Value).To(Equal(100))
+		})
+
+		It("reports that an existing model was not created, reading its version", func() {
+			existing := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(existing).Error).To(Succeed())
+			existing.Value = 200
+			Expect(db.Updates(existing).Error).To(Succeed())
+
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 300}
+			inserted, err := optimistic.CreateIfAbsent(db, m)
+			Expect(err).To(Succeed())
+			Expect(inserted).To(BeFalse())
+			Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+			Expect(persisted().Value).To(Equal(200))
+
+			Expect(optimistic.Reload(db, m)).To(Succeed())
+			Expect(m.Value).To(Equal(200))
+		})
+	})
+
+	It("increments the version of each existing row when upserting a slice", func() {
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		models := []TestModel{
+			{Model: gorm.Model{ID: TestID}, Value: 200},
+			{Model: gorm.Model{ID: TestID + 1}, Value: 200},
+		}
+		Expect(db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models).Error).To(Succeed())
+		Expect(models[0].Version).To(BeNumerically("==", 2))
+		Expect(models[1].Version).To(BeNumerically("==", 1))
+		Expect(persisted().Version).To(BeNumerically("==", 2))
+	})
+
+	It("increments tagged version fields with the plugin installed", func() {
+		Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+
+		upsert := clause.OnConflict{UpdateAll: true}
+		Expect(db.Clauses(upsert).Create(&RevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+		m := &RevisionedModel{ID: TestID, Value: 200}
+		Expect(db.Clauses(upsert).Create(m).Error).To(Succeed())
+		Expect(m.Rev).To(BeNumerically("==", 1))
+
+		p := &RevisionedModel{}
+		Expect(db.First(p, TestID).Error).To(Succeed())
+		Expect(p.Value).To(Equal(200))
+		Expect(p.Rev).To(BeNumerically("==", 1))
+	})
+})
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("AllowVersionAhead", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeVersionAhead := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			// read the model at a version that can be moved behind
+			first := &TestModel{}
+			Expect(db.First(first, TestID).Error).To(Succeed())
+			first.Value = 150
+			Expect(db.Updates(first).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func() *TestModel {
+			p := &TestModel{}
+			Expect(db.Unscoped().First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("writes a version set ahead of the read version", func() {
+			m.Value = 200
+			m.Version = 10
+			Expect(db.Scopes(optimistic.AllowVersionAhead).Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 10))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 10))
+		})
+
+		It("moves a version left as it was read on by one", func() {
+			m.Value = 200
+			Expect(db.Scopes(optimistic.AllowVersionAhead).Updates(m).Error).To(Succeed())
+			Expect(persisted().Version).To(BeNumerically("==", 3))
+		})
+
+		It("rejects a version set behind the read version", func() {
+			m.Value = 200
+			m.Version = 1
+			err := db.Scopes(optimistic.AllowVersionAhead).Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrVersionBehind))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(150))
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		It("still rejects an update to a stale model", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			m.Version = 10
+			err := db.Scopes(optimistic.AllowVersionAhead).Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(300))
+			Expect(p.Version).To(BeNumerically("==", 3))
+		})
+
+		It("writes a version set ahead of the read version when soft deleting", func() {
+			m.Version = 10
+			Expect(db.Scopes(optimistic.AllowVersionAhead).Delete(m).Error).To(Succeed())
+
+			p := persisted()
+			Expect(p.DeletedAt.Valid).To(BeTrue())
+			Expect(p.Version).To(BeNumerically("==", 10))
+		})
+
+		It("overwrites a version set ahead of the read version outside of the scope", func() {
+			m.Value = 200
+			m.Version = 10
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(persisted().Version).To(BeNumerically("==", 3))
+		})
+	}
+
+	describeVersionAhead(false)
+
+	When("using the plugin", func() {
+		describeVersionAhead(true)
+	})
+})
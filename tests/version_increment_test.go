@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("WithVersionIncrement", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeVersionIncrement := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{}, &RevisionedModel{})).To(Succeed())
+
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func() *TestModel {
+			p := &TestModel{}
+			Expect(db.First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("moves the version on by the given delta", func() {
+			m.Value = 200
+			Expect(optimistic.WithVersionIncrement(db, 5).Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 6))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(200))
+			Expect(p.Version).To(BeNumerically("==", 6))
+		})
+
+		if usePlugin {
+			// tagged version fields are only locked by the plugin
+			It("moves tagged version fields on by the given delta", func() {
+				r := &RevisionedModel{ID: TestID, Value: 100}
+				Expect(db.Create(r).Error).To(Succeed())
+
+				r.Value = 200
+				Expect(optimistic.WithVersionIncrement(db, 5).Updates(r).Error).To(Succeed())
+
+				p := &RevisionedModel{}
+				Expect(db.First(p, TestID).Error).To(Succeed())
+				Expect(p.Rev).To(BeNumerically("==", r.Rev))
+				Expect(p.Rev).To(BeNumerically("==", 5))
+			})
+
+			It("restores tagged version fields to the version they were read at on conflict", func() {
+				Expect(db.Create(&RevisionedModel{ID: TestID, Value: 100}).Error).To(Succeed())
+				Expect(db.Updates(&RevisionedModel{ID: TestID, Value: 200}).Error).To(Succeed())
+
+				stale := &RevisionedModel{}
+				Expect(db.First(stale, TestID).Error).To(Succeed())
+				Expect(stale.Rev).To(BeNumerically("==", 1))
+
+				other := &RevisionedModel{}
+				Expect(db.First(other, TestID).Error).To(Succeed())
+				other.Value = 300
+				Expect(db.Updates(other).Error).To(Succeed())
+
+				stale.Value = 400
+				err := optimistic.WithVersionIncrement(db, 5).Updates(stale).Error
+				Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+				Expect(stale.Rev).To(BeNumerically("==", 1))
+
+				var conflict *optimistic.ConflictError
+				Expect(errors.As(err, &conflict)).To(BeTrue())
+				Expect(conflict.ExpectedVersion).To(BeNumerically("==", 1))
+			})
+		}
+
+		It("still guards on the version the model was read at", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			err := optimistic.WithVersionIncrement(db, 5).Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrConcurrentModification))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(300))
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		It("rejects a delta of zero", func() {
+			m.Value = 200
+			err := optimistic.WithVersionIncrement(db, 0).Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrInvalidVersionIncrement))
+			Expect(persisted().Version).To(BeNumerically("==", 1))
+		})
+	}
+
+	describeVersionIncrement(false)
+
+	When("using the plugin", func() {
+		describeVersionIncrement(true)
+	})
+})
@@ -0,0 +1,440 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Versioned", func() {
+	var db *gorm.DB
+	var closeDB func()
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	Describe("ReadVersion", func() {
+		It("is zero for a model that has never been read or created", func() {
+			m := &TestModel{}
+			Expect(m.ReadVersion()).To(BeNumerically("==", 0))
+		})
+
+		It("reflects the version the model was read at rather than its in-memory version", func() {
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 2))
+			Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+
+			m.Value = 300
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 3))
+			Expect(m.ReadVersion()).To(BeNumerically("==", 2))
+		})
+	})
+
+	Describe("SetReadVersion", func() {
+		var received *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			// send the model to a client and receive it back again
+			sent := &TestModel{}
+			Expect(db.First(sent, TestID).Error).To(Succeed())
+			body, err := json.Marshal(sent)
+			Expect(err).To(Succeed())
+
+			received = &TestModel{}
+			Expect(json.Unmarshal(body, received)).To(Succeed())
+			Expect(received.ReadVersion()).To(BeNumerically("==", 0))
+			received.SetReadVersion(received.Version)
+		})
+
+		It("allows a deserialized model to be updated", func() {
+			received.Value = 200
+			Expect(db.Updates(received).Error).To(Succeed())
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(200))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+		})
+
+		It("detects modification since the client read the model", func() {
+			m := &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			m.Value = 300
+			Expect(db.Updates(m).Error).To(Succeed())
+
+			received.Value = 200
+			Expect(db.Updates(received).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+	})
+
+	Describe("VersionAdvanced", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		It("is false for a model that has only been read", func() {
+			Expect(m.VersionAdvanced()).To(BeFalse())
+		})
+
+		It("is true after a successful update, until the model is read again", func() {
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.VersionAdvanced()).To(BeTrue())
+
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			Expect(m.VersionAdvanced()).To(BeFalse())
+		})
+
+		It("is true after a successful soft delete", func() {
+			Expect(db.Delete(m).Error).To(Succeed())
+			Expect(m.VersionAdvanced()).To(BeTrue())
+		})
+
+		It("is false after a conflicting update", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(m.VersionAdvanced()).To(BeFalse())
+		})
+
+		It("is true after a successful update made with the plugin installed", func() {
+			Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.VersionAdvanced()).To(BeTrue())
+		})
+	})
+
+	Describe("updating a model that was never read", func() {
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+		})
+
+		It("explains that the model must be read first, rather than reporting a conflict", func() {
+			m := &TestModel{Model: gorm.Model{ID: TestID}, Value: 200}
+			err := db.Updates(m).Error
+			Expect(err).To(MatchError(optimistic.ErrUnknownReadVersion))
+			Expect(optimistic.IsConflict(err)).To(BeFalse())
+
+			err = db.Model(&TestModel{Model: gorm.Model{ID: TestID}}).Update("value", 200).Error
+			Expect(err).To(MatchError(optimistic.ErrUnknownReadVersion))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(100))
+			Expect(persisted.Version).To(BeNumerically("==", 1))
+		})
+
+		It("still allows the model to be saved", func() {
+			Expect(db.Save(&TestModel{Model: gorm.Model{ID: TestID + 1}, Value: 200}).Error).To(Succeed())
+		})
+	})
+
+	When("loading a slice of models", func() {
+		JustBeforeEach(func() {
+			// create three models, each updated a different number of times
+			for i := 1; i <= 3; i++ {
+				m := &TestModel{Model: gorm.Model{ID: uint(i)}}
+				Expect(db.Create(m).Error).To(Succeed())
+
+				for update := 1; update < i; update++ {
+					Expect(db.First(m, i).Error).To(Succeed())
+					m.Value = update
+					Expect(db.Updates(m).Error).To(Succeed())
+				}
+			}
+		})
+
+		It("tracks the read version of each element", func() {
+			var models []TestModel
+			Expect(db.Order("id").Find(&models).Error).To(Succeed())
+			Expect(models).To(HaveLen(3))
+
+			for i := range models {
+				Expect(models[i].ReadVersion()).To(BeNumerically("==", i+1))
+			}
+
+			for i := range models {
+				models[i].Value = 100
+				Expect(db.Updates(&models[i]).Error).To(Succeed())
+				Expect(models[i].Version).To(BeNumerically("==", i+2))
+			}
+
+			var persisted []TestModel
+			Expect(db.Order("id").Find(&persisted).Error).To(Succeed())
+			for i := range persisted {
+				Expect(persisted[i].Version).To(BeNumerically("==", i+2))
+			}
+		})
+
+		It("tracks the read version of each element of a slice of pointers", func() {
+			var models []*TestModel
+			Expect(db.Order("id").Find(&models).Error).To(Succeed())
+			Expect(models).To(HaveLen(3))
+
+			for i, m := range models {
+				Expect(m.ReadVersion()).To(BeNumerically("==", i+1))
+
+				m.Value = 100
+				Expect(db.Updates(m).Error).To(Succeed())
+				Expect(m.Version).To(BeNumerically("==", i+2))
+			}
+		})
+	})
+
+	When("creating a batch of models", func() {
+		var models []TestModel
+
+		JustBeforeEach(func() {
+			models = make([]TestModel, 5)
+			for i := range models {
+				models[i].Value = i
+			}
+			Expect(db.Create(&models).Error).To(Succeed())
+		})
+
+		It("tracks the read version of each element", func() {
+			for i := range models {
+				Expect(models[i].Version).To(BeNumerically("==", 1))
+				Expect(models[i].ReadVersion()).To(BeNumerically("==", 1))
+			}
+		})
+
+		It("allows an element to be updated", func() {
+			third := &models[2]
+			third.Value = 100
+			Expect(db.Updates(third).Error).To(Succeed())
+			Expect(third.Version).To(BeNumerically("==", 2))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, third.ID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(100))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+		})
+
+		It("tracks the read version of each element when created in batches", func() {
+			batched := make([]*TestModel, 5)
+			for i := range batched {
+				batched[i] = &TestModel{Value: i}
+			}
+			Expect(db.CreateInBatches(batched, 2).Error).To(Succeed())
+
+			for _, m := range batched {
+				Expect(m.ReadVersion()).To(BeNumerically("==", 1))
+			}
+
+			batched[2].Value = 100
+			Expect(db.Updates(batched[2]).Error).To(Succeed())
+			Expect(batched[2].Version).To(BeNumerically("==", 2))
+		})
+	})
+
+	When("loading models in batches", func() {
+		JustBeforeEach(func() {
+			models := make([]TestModel, 50)
+			Expect(db.Create(&models).Error).To(Succeed())
+		})
+
+		It("tracks the read version of each element of every batch", func() {
+			batches := 0
+			var models []TestModel
+			Expect(db.FindInBatches(&models, 10, func(tx *gorm.DB, batch int) error {
+				batches++
+				Expect(models).To(HaveLen(10))
+				for i := range models {
+					Expect(models[i].ReadVersion()).To(BeNumerically("==", 1))
+					models[i].Value = batch
+					Expect(db.Updates(&models[i]).Error).To(Succeed())
+				}
+				return nil
+			}).Error).To(Succeed())
+			Expect(batches).To(Equal(5))
+
+			var persisted []TestModel
+			Expect(db.Find(&persisted).Error).To(Succeed())
+			Expect(persisted).To(HaveLen(50))
+			for _, m := range persisted {
+				Expect(m.Version).To(BeNumerically("==", 2))
+			}
+		})
+	})
+
+	When("an update leaves the model's values unchanged", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		expectVersion := func(version uint64) {
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(100))
+			Expect(persisted.Version).To(BeNumerically("==", version))
+		}
+
+		It("still increments the version when updating from a struct", func() {
+			Expect(db.Updates(m).Error).To(Succeed())
+			expectVersion(2)
+		})
+
+		It("still increments the version when updating a single column", func() {
+			Expect(db.Model(m).Update("value", 100).Error).To(Succeed())
+			expectVersion(2)
+		})
+
+		It("still increments the version when only other columns are selected", func() {
+			Expect(db.Model(m).Select("Value").Updates(map[string]interface{}{"value": 100}).Error).To(Succeed())
+			expectVersion(2)
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+			selected := db.Select("value").Session(&gorm.Session{})
+			Expect(selected.Updates(m).Error).To(Succeed())
+			expectVersion(3)
+			Expect(selected.Statement.Selects).To(Equal([]string{"value"}))
+		})
+
+		It("still increments the version when it is omitted", func() {
+			Expect(db.Omit("version").Updates(m).Error).To(Succeed())
+			expectVersion(2)
+		})
+	})
+
+	When("an update is restricted to selected columns", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		It("writes the selected columns and increments the version", func() {
+			createdAt := m.CreatedAt
+			m.Value = 200
+			m.CreatedAt = createdAt.Add(time.Hour)
+			Expect(db.Select("value").Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 2))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(200))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+			Expect(persisted.CreatedAt).To(BeTemporally("==", createdAt))
+		})
+
+		It("still detects concurrent modifications", func() {
+			other := &TestModel{}
+			Expect(db.First(other, TestID).Error).To(Succeed())
+			other.Value = 300
+			Expect(db.Select("value").Updates(other).Error).To(Succeed())
+
+			m.Value = 200
+			Expect(db.Select("value").Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+			persisted := &TestModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.Value).To(Equal(300))
+			Expect(persisted.Version).To(BeNumerically("==", 2))
+		})
+	})
+
+	When("an update omits the version", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		for _, omitted := range []string{"version", "Version"} {
+			omitted := omitted
+
+			It(fmt.Sprintf("still increments the version when omitting %q", omitted), func() {
+				m.Value = 200
+				Expect(db.Omit(omitted).Updates(m).Error).To(Succeed())
+				Expect(m.Version).To(BeNumerically("==", 2))
+
+				persisted := &TestModel{}
+				Expect(db.First(persisted, TestID).Error).To(Succeed())
+				Expect(persisted.Value).To(Equal(200))
+				Expect(persisted.Version).To(BeNumerically("==", 2))
+			})
+
+			It(fmt.Sprintf("still detects concurrent modifications when omitting %q", omitted), func() {
+				other := &TestModel{}
+				Expect(db.First(other, TestID).Error).To(Succeed())
+				other.Value = 300
+				Expect(db.Omit(omitted).Updates(other).Error).To(Succeed())
+
+				m.Value = 200
+				Expect(db.Omit(omitted).Updates(m).Error).To(MatchError(optimistic.ErrConcurrentModification))
+
+				persisted := &TestModel{}
+				Expect(db.First(persisted, TestID).Error).To(Succeed())
+				Expect(persisted.Value).To(Equal(300))
+				Expect(persisted.Version).To(BeNumerically("==", 2))
+			})
+		}
+	})
+
+	When("the version cannot be incremented any further", func() {
+		var m *TestModel
+
+		JustBeforeEach(func() {
+			// sqlite cannot store the largest uint64, so "create" the model without executing any SQL to reach a read
+			// version of math.MaxUint64
+			m = &TestModel{Model: gorm.Model{ID: TestID}, Versioned: optimistic.Versioned{Version: math.MaxUint64}}
+			Expect(db.Session(&gorm.Session{DryRun: true}).Create(m).Error).To(Succeed())
+			Expect(m.ReadVersion()).To(BeNumerically("==", uint64(math.MaxUint64)))
+		})
+
+		It("fails to update rather than wrapping around", func() {
+			m.Value = 100
+			Expect(db.Updates(m).Error).To(MatchError(optimistic.ErrVersionOverflow))
+			Expect(m.Version).To(BeNumerically("==", uint64(math.MaxUint64)))
+		})
+
+		It("fails to soft delete rather than wrapping around", func() {
+			Expect(db.Delete(m).Error).To(MatchError(optimistic.ErrVersionOverflow))
+		})
+	})
+})
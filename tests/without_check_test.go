@@ -0,0 +1,77 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("WithoutVersionCheck", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var stale *TestModel
+
+	JustBeforeEach(func() {
+		db, closeDB = openTestDB()
+		Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+		Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+		stale = &TestModel{}
+		Expect(db.First(stale, TestID).Error).To(Succeed())
+
+		other := &TestModel{}
+		Expect(db.First(other, TestID).Error).To(Succeed())
+		other.Value = 200
+		Expect(db.Updates(other).Error).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		closeDB()
+	})
+
+	It("applies stale updates without a conflict", func() {
+		session := optimistic.WithoutVersionCheck(db)
+
+		stale.Value = 300
+		Expect(session.Updates(stale).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+	})
+
+	It("applies to every statement in the session", func() {
+		session := optimistic.WithoutVersionCheck(db)
+
+		stale.Value = 300
+		Expect(session.Updates(stale).Error).To(Succeed())
+		stale.Value = 400
+		Expect(session.Updates(stale).Error).To(Succeed())
+		Expect(session.Delete(stale).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.Unscoped().First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(400))
+		Expect(persisted.DeletedAt.Valid).To(BeTrue())
+	})
+
+	It("leaves the version of models that were never read unchanged", func() {
+		session := optimistic.WithoutVersionCheck(db)
+		Expect(session.Model(&TestModel{Model: gorm.Model{ID: TestID}}).Update("value", 300).Error).To(Succeed())
+
+		persisted := &TestModel{}
+		Expect(db.First(persisted, TestID).Error).To(Succeed())
+		Expect(persisted.Value).To(Equal(300))
+		Expect(persisted.Version).To(BeNumerically("==", 2))
+	})
+
+	It("does not affect statements outside the session", func() {
+		_ = optimistic.WithoutVersionCheck(db)
+
+		stale.Value = 300
+		Expect(db.Updates(stale).Error).To(MatchError(optimistic.ErrConcurrentModification))
+	})
+})
@@ -0,0 +1,109 @@
+//go:build postgres
+// +build postgres
+
+package tests
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+// postgresDSNVariable names the environment variable giving the PostgreSQL database to run integration tests against
+const postgresDSNVariable = "OPTIMISTIC_POSTGRES_DSN"
+
+type XminModel struct {
+	gorm.Model
+	optimistic.XminVersioned
+
+	Value int
+}
+
+var _ = Describe("XminVersioned", func() {
+	var db *gorm.DB
+
+	JustBeforeEach(func() {
+		dsn := os.Getenv(postgresDSNVariable)
+		if dsn == "" {
+			Skip(postgresDSNVariable + " is not set")
+		}
+
+		var err error
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{SkipDefaultTransaction: true})
+		Expect(err).To(Succeed())
+		db = db.Debug()
+
+		Expect(db.Migrator().DropTable(&XminModel{})).To(Succeed())
+		Expect(db.AutoMigrate(&XminModel{})).To(Succeed())
+	})
+
+	JustAfterEach(func() {
+		if db == nil {
+			return
+		}
+
+		Expect(db.Migrator().DropTable(&XminModel{})).To(Succeed())
+		sqlDB, err := db.DB()
+		Expect(err).To(Succeed())
+		Expect(sqlDB.Close()).To(Succeed())
+	})
+
+	When("an entry is created", func() {
+		var m *XminModel
+
+		JustBeforeEach(func() {
+			m = &XminModel{Model: gorm.Model{ID: TestID}, Value: 100}
+			Expect(db.Create(m).Error).To(Succeed())
+		})
+
+		It("reads back the xmin of the created row", func() {
+			Expect(m.ReadXmin()).NotTo(BeZero())
+
+			persisted := &XminModel{}
+			Expect(db.First(persisted, TestID).Error).To(Succeed())
+			Expect(persisted.ReadXmin()).To(Equal(m.ReadXmin()))
+		})
+
+		It("allows sequential updates of the same model", func() {
+			created := m.ReadXmin()
+
+			m.Value = 200
+			Expect(db.Updates(m).Error).To(Succeed())
+			Expect(m.ReadXmin()).NotTo(Equal(created))
+
+			m.Value = 300
+			Expect(db.Updates(m).Error).To(Succeed())
+		})
+
+		It("detects concurrent updates", func() {
+			a := &XminModel{}
+			b := &XminModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			b.Value = 300
+			Expect(db.Updates(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+
+		It("detects deleting a concurrently modified entry", func() {
+			a := &XminModel{}
+			b := &XminModel{}
+			Expect(db.First(a, TestID).Error).To(Succeed())
+			Expect(db.First(b, TestID).Error).To(Succeed())
+
+			a.Value = 200
+			Expect(db.Updates(a).Error).To(Succeed())
+
+			Expect(db.Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+			Expect(db.Unscoped().Delete(b).Error).To(MatchError(optimistic.ErrConcurrentModification))
+		})
+	})
+})
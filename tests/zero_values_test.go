@@ -0,0 +1,74 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/gorm"
+
+	"github.com/omaskery/optimistic-gorm/optimistic"
+)
+
+var _ = Describe("Updates of zero valued fields", func() {
+	var db *gorm.DB
+	var closeDB func()
+	var m *TestModel
+
+	describeZeroValues := func(usePlugin bool) {
+		JustBeforeEach(func() {
+			db, closeDB = openTestDB()
+			if usePlugin {
+				Expect(db.Use(optimistic.NewPlugin(optimistic.PluginOptions{}))).To(Succeed())
+			}
+			Expect(db.AutoMigrate(&TestModel{})).To(Succeed())
+
+			Expect(db.Create(&TestModel{Model: gorm.Model{ID: TestID}, Value: 100}).Error).To(Succeed())
+
+			m = &TestModel{}
+			Expect(db.First(m, TestID).Error).To(Succeed())
+		})
+
+		JustAfterEach(func() {
+			closeDB()
+		})
+
+		persisted := func() *TestModel {
+			p := &TestModel{}
+			Expect(db.First(p, TestID).Error).To(Succeed())
+			return p
+		}
+
+		It("advances the version when every business field is zero", func() {
+			Expect(db.Updates(&TestModel{Model: gorm.Model{ID: TestID}, Versioned: m.Versioned}).Error).To(Succeed())
+
+			p := persisted()
+			Expect(p.Value).To(Equal(100))
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		It("advances the version when only a zero valued field is selected", func() {
+			m.Value = 0
+			Expect(db.Select("value").Updates(m).Error).To(Succeed())
+			Expect(m.Version).To(BeNumerically("==", 2))
+
+			p := persisted()
+			Expect(p.Value).To(Equal(0))
+			Expect(p.Version).To(BeNumerically("==", 2))
+		})
+
+		It("writes an explicit version of zero", func() {
+			m.Value = 0
+			m.Version = 0
+			Expect(db.Scopes(optimistic.ExplicitVersion).Updates(m).Error).To(Succeed())
+
+			p := persisted()
+			Expect(p.Value).To(Equal(100))
+			Expect(p.Version).To(BeNumerically("==", 0))
+		})
+	}
+
+	describeZeroValues(false)
+
+	When("using the plugin", func() {
+		describeZeroValues(true)
+	})
+})